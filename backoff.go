@@ -0,0 +1,57 @@
+package nutclient
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff calculates the interval between attempts to reconnect, doubling it
+// after each failed attempt up to the configured maximum.
+type backoff struct {
+	cfg      *Config
+	rand     *rand.Rand
+	interval time.Duration
+	jitterFn func(time.Duration) time.Duration
+}
+
+func newBackoff(cfg *Config) *backoff {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &backoff{
+		cfg:  cfg,
+		rand: r,
+		jitterFn: func(d time.Duration) time.Duration {
+			return time.Duration(r.Int63n(int64(d/10) + 1))
+		},
+	}
+}
+
+// startup returns the delay before the first attempt to connect, chosen at
+// random from [0, StartupJitter).
+func (b *backoff) startup() time.Duration {
+	if b.cfg.StartupJitter <= 0 {
+		return 0
+	}
+	return time.Duration(b.rand.Int63n(int64(b.cfg.StartupJitter)))
+}
+
+// next returns the interval to wait before the next attempt. Up to 10% of the
+// interval is added at random so that clients disconnected at the same time
+// do not reconnect in lockstep.
+func (b *backoff) next() time.Duration {
+	switch {
+	case b.interval == 0:
+		b.interval = b.cfg.getReconnectInterval()
+	case b.interval < b.cfg.getMaxReconnectInterval():
+		b.interval *= 2
+		if max := b.cfg.getMaxReconnectInterval(); b.interval > max {
+			b.interval = max
+		}
+	}
+	return b.interval + b.jitterFn(b.interval)
+}
+
+// reset returns the interval to its initial value after a successful
+// connection.
+func (b *backoff) reset() {
+	b.interval = 0
+}
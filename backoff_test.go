@@ -0,0 +1,68 @@
+package nutclient
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	for _, v := range []struct {
+		name   string
+		cfg    *Config
+		output []time.Duration
+	}{
+		{
+			name: "default",
+			cfg:  &Config{},
+			output: []time.Duration{
+				30 * time.Second,
+				30 * time.Second,
+				30 * time.Second,
+			},
+		},
+		{
+			name: "exponential",
+			cfg: &Config{
+				ReconnectInterval:    5 * time.Second,
+				MaxReconnectInterval: 30 * time.Second,
+			},
+			output: []time.Duration{
+				5 * time.Second,
+				10 * time.Second,
+				20 * time.Second,
+				30 * time.Second,
+				30 * time.Second,
+			},
+		},
+	} {
+		var (
+			b = &backoff{
+				cfg: v.cfg,
+				jitterFn: func(time.Duration) time.Duration {
+					return 0
+				},
+			}
+			output = []time.Duration{}
+		)
+		for range v.output {
+			output = append(output, b.next())
+		}
+		if !reflect.DeepEqual(v.output, output) {
+			t.Fatalf("%s: %#v != %#v", v.name, v.output, output)
+		}
+		b.reset()
+		if d := b.next(); d != v.output[0] {
+			t.Fatalf("%s: %#v != %#v", v.name, v.output[0], d)
+		}
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := newBackoff(&Config{})
+	for i := 0; i < 100; i++ {
+		if d := b.next(); d < 30*time.Second || d > 33*time.Second {
+			t.Fatalf("%s out of range", d)
+		}
+	}
+}
@@ -2,27 +2,104 @@ package nutclient
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"golang.org/x/exp/maps"
 )
 
-var errInvalidStatus = errors.New("invalid response received from NUT server")
+// logoutTimeout is the maximum amount of time to wait for the server to
+// acknowledge LOGOUT when closing the client.
+const logoutTimeout = 2 * time.Second
+
+var errNotConnected = errors.New("not connected to NUT server")
+
+type cmdType int
+
+const (
+	typeList cmdType = iota
+	typeCmd
+	typeSet
+	typeGet
+	typeRaw
+	typeLine
+	typeListSeq
+	typeLogout
+)
+
+type cmdResponse struct {
+	v   any
+	err error
+}
+
+type cmdRequest struct {
+	ctx          context.Context
+	cmdType      cmdType
+	args         []string
+	retries      int
+	responseChan chan *cmdResponse
+
+	// For typeListSeq, each row is sent to rowChan until stopChan is closed
+	rowChan  chan []string
+	stopChan chan any
+}
 
 // Client connects to a NUT server and monitors it for events.
 type Client struct {
-	mutex      sync.RWMutex
-	lastStatus map[string]string
-	onBattery  bool
-	cfg        *Config
-	ctx        context.Context
-	cancel     context.CancelFunc
-	closedChan chan any
+	connected      int32
+	mutex          sync.RWMutex
+	remoteAddr     net.Addr
+	addrIndex      int
+	connectedAt    time.Time
+	stats          Stats
+	name           string
+	rebaseline     bool
+	lastStatus     map[string]string
+	status         string
+	baselined      bool
+	onBattery      bool
+	pendingPower   bool
+	debounceChan   <-chan time.Time
+	metrics        Metrics
+	history        []StatusEvent
+	lowBattery     bool
+	replaceBattery bool
+	calibration    bool
+	belowCharge    bool
+	belowRuntime   bool
+	lastCharge     float64
+	hasLastCharge  bool
+	watchedVars    map[string]string
+	cfg            *Config
+	clock          clock
+	backoff        *backoff
+	failures       int
+	disconnectedAt time.Time
+	pending        []*cmdRequest
+	ctx            context.Context
+	cancel         context.CancelFunc
+	requestChan    chan *cmdRequest
+	poolChan       chan *cmdRequest
+	eventChan      chan Event
+	closedChan     chan any
+	poolWait       sync.WaitGroup
+}
+
+// wrapClosed wraps errors indicating that the server closed the connection
+// with ErrServerClosed.
+func wrapClosed(err error) error {
+	if errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) {
+		return fmt.Errorf("%w: %s", ErrServerClosed, err)
+	}
+	return err
 }
 
 func (c *Client) runCommand(conn net.Conn, cmd string, r responseReader) (cErr error) {
@@ -52,109 +129,727 @@ func (c *Client) runCommand(conn net.Conn, cmd string, r responseReader) (cErr e
 		close(errChan)
 	}()
 
-	// Write the command
+	// Write the command, giving up if the server stops reading
+	conn.SetWriteDeadline(time.Now().Add(c.cfg.getWriteTimeout()))
+	defer conn.SetWriteDeadline(time.Time{})
 	if _, err := conn.Write([]byte(cmd + "\n")); err != nil {
-		cErr = err
+		cErr = wrapClosed(err)
 		return
 	}
 
-	// Read the response
+	// Read the response, giving up if it takes too long
+	conn.SetReadDeadline(time.Now().Add(c.cfg.getCommandTimeout()))
+	defer conn.SetReadDeadline(time.Time{})
 	if err := r.parse(conn); err != nil {
-		cErr = err
+		cErr = wrapClosed(err)
 		return
 	}
 
 	return
 }
 
-func (c *Client) getStatus(conn net.Conn, l *listReader) (bool, error) {
+func (c *Client) getStatus(conn net.Conn, l *listReader, name string) (map[string]string, error) {
 	if err := c.runCommand(
 		conn,
-		fmt.Sprintf("LIST VAR %s", c.cfg.getName()),
+		fmt.Sprintf("LIST VAR %s", name),
 		l,
 	); err != nil {
-		return false, err
+		return nil, err
+	}
+	variables, err := parseVariables(l.rows)
+	if err != nil {
+		return nil, err
 	}
 	func() {
 		c.mutex.Lock()
 		defer c.mutex.Unlock()
-		c.lastStatus = l.variables
+		c.lastStatus = variables
 	}()
-	v := l.variables["ups.status"]
-	switch {
-	case strings.HasPrefix(v, "OL"):
-		return false, nil
-	case v == "OB" || v == "LB":
-		return true, nil
-	default:
-		return false, errInvalidStatus
+	return variables, nil
+}
+
+func (c *Client) runList(conn net.Conn, args []string) ([][]string, error) {
+	l := &listReader{maxRows: c.cfg.getMaxListRows()}
+	if err := c.runCommand(
+		conn,
+		fmt.Sprintf("LIST %s", formatCommand(args)),
+		l,
+	); err != nil {
+		return nil, err
 	}
+	return l.rows, nil
 }
 
-func (c *Client) loop(conn net.Conn) error {
+// runListSeq passes each row of the list to the caller as it is read. If the
+// caller stops receiving rows (or the client is closed), the remainder of the
+// list is still read (and discarded) so that the connection can be used for
+// the next command.
+func (c *Client) runListSeq(conn net.Conn, r *cmdRequest) error {
+	l := &listReader{
+		maxRows: c.cfg.getMaxListRows(),
+		rowFn: func(row []string) {
+			select {
+			case r.rowChan <- row:
+			case <-r.stopChan:
+			case <-c.ctx.Done():
+			}
+		},
+	}
+	return c.runCommand(
+		conn,
+		fmt.Sprintf("LIST %s", formatCommand(r.args)),
+		l,
+	)
+}
 
-	// Clear the lastStatus on disconnect since it is now out of date
-	defer func() {
+func (c *Client) runGet(conn net.Conn, args []string) ([]string, error) {
+	l := &lineReader{}
+	if err := c.runCommand(
+		conn,
+		fmt.Sprintf("GET %s", formatCommand(args)),
+		l,
+	); err != nil {
+		return nil, err
+	}
+	v, ok := trimPrefix(l.tokens, args...)
+	if !ok {
+		return nil, errPrefixMismatch
+	}
+	return v, nil
+}
+
+func (c *Client) runRaw(conn net.Conn, args []string) (string, error) {
+	r := &rawReader{}
+	if err := c.runCommand(conn, strings.Join(args, " "), r); err != nil {
+		return "", err
+	}
+	return r.line, nil
+}
+
+func (c *Client) runLine(conn net.Conn, args []string) ([]string, error) {
+	l := &lineReader{}
+	if err := c.runCommand(conn, strings.Join(args, " "), l); err != nil {
+		return nil, err
+	}
+	return l.tokens, nil
+}
+
+// runCmd runs a command that is expected to reply with OK, returning any
+// tokens that follow it (such as "Done" for "OK Done").
+func (c *Client) runCmd(conn net.Conn, args []string) ([]string, error) {
+	return c.runOk(conn, formatCommand(args))
+}
+
+// runSet runs SET VAR, which unlike other commands always quotes the value
+// (the last argument) since the protocol requires it.
+func (c *Client) runSet(conn net.Conn, args []string) ([]string, error) {
+	n := len(args) - 1
+	return c.runOk(conn, fmt.Sprintf("%s %s", formatCommand(args[:n]), quote(args[n])))
+}
+
+func (c *Client) runOk(conn net.Conn, cmd string) ([]string, error) {
+	l := &lineReader{}
+	if err := c.runCommand(conn, cmd, l); err != nil {
+		return nil, err
+	}
+	v, ok := trimPrefix(l.tokens, "ok")
+	if !ok {
+		return nil, errOkExpected
+	}
+	return v, nil
+}
+
+// handleRequest runs the command and sends the response to the caller. If the
+// connection fails and the command may be retried, it is added to pending
+// instead so that it can be run again once the connection is reestablished.
+func (c *Client) handleRequest(conn net.Conn, r *cmdRequest, pending *[]*cmdRequest) error {
+	var (
+		v   any
+		err error
+	)
+
+	// Skip the command if the caller is no longer waiting for it
+	if err := r.ctx.Err(); err != nil {
+		r.responseChan <- &cmdResponse{err: err}
+		return nil
+	}
+
+	switch r.cmdType {
+	case typeList:
+		v, err = c.runList(conn, r.args)
+	case typeGet:
+		v, err = c.runGet(conn, r.args)
+	case typeRaw:
+		v, err = c.runRaw(conn, r.args)
+	case typeLine:
+		v, err = c.runLine(conn, r.args)
+	case typeListSeq:
+		err = c.runListSeq(conn, r)
+	case typeSet:
+		v, err = c.runSet(conn, r.args)
+	case typeCmd, typeLogout:
+		v, err = c.runCmd(conn, r.args)
+	}
+	func() {
 		c.mutex.Lock()
 		defer c.mutex.Unlock()
-		c.lastStatus = nil
+		c.stats.CommandCount++
+		if err != nil {
+			c.stats.CommandErrors++
+		}
 	}()
+	if err != nil &&
+		!isProtocolError(err) &&
+		!errors.Is(err, context.Canceled) &&
+		r.cmdType != typeLogout &&
+		r.cmdType != typeListSeq &&
+		r.retries < c.cfg.CommandRetries {
+		r.retries++
+		*pending = append(*pending, r)
+		return err
+	}
+	r.responseChan <- &cmdResponse{v: v, err: err}
 
-	// Create the response reader for the session
-	l := &listReader{}
+	// The server closes the connection after LOGOUT, so shut down the client
+	// rather than reconnecting
+	if r.cmdType == typeLogout {
+		c.cancel()
+		return context.Canceled
+	}
 
-	// Retrieve the status every n seconds until an error occurs
-	for {
+	// An error returned by the server leaves the connection usable; anything
+	// else means the connection must be reestablished
+	if isProtocolError(err) {
+		return nil
+	}
+	return err
+}
 
-		// Get the current power status
-		onBattery, err := c.getStatus(conn, l)
-		if err != nil {
-			return err
-		}
+func (c *Client) logf(format string, v ...any) {
+	if c.cfg.Logger != nil {
+		c.cfg.Logger.Printf(format, v...)
+	}
+}
+
+// takeName returns the name of the UPS to poll and whether it was changed
+// since the last poll.
+func (c *Client) takeName() (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	rebaseline := c.rebaseline
+	c.rebaseline = false
+	return c.name, rebaseline
+}
 
-		// If status != last status, then a power change has occurred
-		switch {
-		case !c.onBattery && onBattery && c.cfg.PowerLostFn != nil:
+// debounce returns the power state that should be reported for onBattery.
+// If Debounce is set, a change is held back (and the current state returned)
+// until it has persisted for that long, at which point debounceChan fires.
+func (c *Client) debounce(onBattery bool) bool {
+	if c.cfg.Debounce <= 0 || onBattery == c.onBattery {
+		c.debounceChan = nil
+		return onBattery
+	}
+	if c.debounceChan == nil || c.pendingPower != onBattery {
+		c.pendingPower = onBattery
+		c.debounceChan = c.clock.After(c.cfg.Debounce)
+	}
+	return c.onBattery
+}
+
+// applyPending reports the change in power that was held back by debounce.
+func (c *Client) applyPending() {
+	c.debounceChan = nil
+	wasOnBattery := c.onBattery
+	func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.onBattery = c.pendingPower
+		c.metrics.OnBattery = c.pendingPower
+	}()
+	c.reportPower(wasOnBattery, c.pendingPower)
+}
+
+// reportPower emits the appropriate event and invokes the appropriate
+// callback if the power state changed.
+func (c *Client) reportPower(wasOnBattery, onBattery bool) {
+	switch {
+	case !wasOnBattery && onBattery:
+		c.emit(PowerLost)
+		if c.cfg.PowerLostFn != nil {
 			c.cfg.PowerLostFn()
-		case c.onBattery && !onBattery && c.cfg.PowerRestoredFn != nil:
+		}
+	case wasOnBattery && !onBattery:
+		c.emit(PowerRestored)
+		if c.cfg.PowerRestoredFn != nil {
 			c.cfg.PowerRestoredFn()
 		}
+	}
+}
+
+func (c *Client) poll(conn net.Conn, l *listReader) error {
+
+	// If the UPS was changed, discard the state of the previous one so that
+	// the new one is treated as if it was just connected
+	name, rebaseline := c.takeName()
+	if rebaseline {
+		func() {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			c.onBattery = false
+			c.metrics = Metrics{}
+		}()
+		c.status = ""
+		c.baselined = false
+		c.debounceChan = nil
+		c.lowBattery = false
+		c.replaceBattery = false
+		c.calibration = false
+		c.belowCharge = false
+		c.belowRuntime = false
+		c.hasLastCharge = false
+		c.watchedVars = map[string]string{}
+	}
+
+	// Get the current power status
+	variables, err := c.getStatus(conn, l, name)
+	if err != nil {
+		return err
+	}
+	var (
+		status         = variables["ups.status"]
+		flags          = ParseStatus(status)
+		lowBattery     = flags.LowBattery
+		replaceBattery = flags.ReplaceBattery
+		calibration    = flags.Calibration
+	)
+
+	// Record and report any change to the raw status
+	if status != c.status {
+		c.record(c.status, status)
+		if c.cfg.StatusChangedFn != nil {
+			c.cfg.StatusChangedFn(c.status, status)
+		}
+	}
+	c.status = status
+
+	// Determine whether the UPS is running on battery; if the status does not
+	// indicate either way, the previous value is retained unless the change
+	// in battery charge is to be used instead
+	charge, chargeErr := strconv.ParseFloat(variables["battery.charge"], 64)
+	onBattery, ok := evaluateStatus(status, c.cfg)
+	switch {
+	case c.cfg.IgnoreCalibration && calibration:
+
+		// The charge falls during calibration, so it cannot be used either
+		onBattery = c.onBattery
+	case !ok:
+		onBattery = c.onBattery
+		if c.cfg.UseChargeTrend && chargeErr == nil && c.hasLastCharge {
+			onBattery = evaluateCharge(charge, c.lastCharge, c.onBattery)
+		}
+	}
+	c.lastCharge, c.hasLastCharge = charge, chargeErr == nil
+	if c.baselined {
+		onBattery = c.debounce(onBattery)
+	}
 
-		// Store status for next iteration
+	// Store the new state (so that it is visible to callbacks), keeping the
+	// previous state to determine what changed
+	var (
+		wasOnBattery      = c.onBattery
+		wasLowBattery     = c.lowBattery
+		wasReplaceBattery = c.replaceBattery
+		wasCalibration    = c.calibration
+	)
+	func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
 		c.onBattery = onBattery
+		c.metrics = newMetrics(variables, onBattery, c.clock.Now())
+	}()
+	c.lowBattery = lowBattery
+	c.replaceBattery = replaceBattery
+	c.calibration = calibration
+
+	// The first reading only establishes whether the UPS is on battery since
+	// there is nothing for it to be a transition from; the battery flags are
+	// still reported since they indicate conditions that need acting upon
+	if !c.baselined {
+		c.baselined = true
+		wasOnBattery = onBattery
+	}
+
+	// If status != last status, then a power change has occurred
+	c.reportPower(wasOnBattery, onBattery)
+
+	// Likewise for the battery becoming low or recovering
+	switch {
+	case !wasLowBattery && lowBattery:
+		c.emit(LowBattery)
+		if c.cfg.LowBatteryFn != nil {
+			c.cfg.LowBatteryFn()
+		}
+	case wasLowBattery && !lowBattery:
+		c.emit(LowBatteryCleared)
+		if c.cfg.LowBatteryClearedFn != nil {
+			c.cfg.LowBatteryClearedFn()
+		}
+	}
+
+	// Report when the battery first needs replacing
+	if !wasReplaceBattery && replaceBattery {
+		c.emit(ReplaceBattery)
+		if c.cfg.ReplaceBatteryFn != nil {
+			c.cfg.ReplaceBatteryFn()
+		}
+	}
+
+	// Report when calibration starts or ends
+	if wasCalibration != calibration && c.cfg.CalibrationFn != nil {
+		c.cfg.CalibrationFn(calibration)
+	}
+
+	// Report any changes to the watched variables
+	for _, name := range c.cfg.WatchVars {
+		v, ok := variables[name]
+		if !ok || v == c.watchedVars[name] {
+			continue
+		}
+		c.watchedVars[name] = v
+		if c.cfg.VarChangedFn != nil {
+			c.cfg.VarChangedFn(name, v)
+		}
+	}
+
+	// Report the battery charge if the UPS provides it
+	if chargeErr == nil {
+		c.checkCharge(charge)
+	}
+
+	// Report the battery runtime if the UPS provides it
+	if v, err := strconv.Atoi(variables["battery.runtime"]); err == nil {
+		c.checkRuntime(v)
+	}
+
+	return nil
+}
+
+func (c *Client) checkCharge(charge float64) {
+	if c.cfg.BatteryChargeFn != nil {
+		c.cfg.BatteryChargeFn(charge)
+	}
+	belowCharge := charge < c.cfg.ChargeThreshold
+	if !c.belowCharge && belowCharge && c.cfg.ChargeThresholdFn != nil {
+		c.cfg.ChargeThresholdFn(charge)
+	}
+	c.belowCharge = belowCharge
+}
+
+func (c *Client) checkRuntime(runtime int) {
+	if c.cfg.RuntimeFn != nil {
+		c.cfg.RuntimeFn(runtime)
+	}
+	belowRuntime := runtime < c.cfg.RuntimeThreshold
+	if !c.belowRuntime && belowRuntime && c.cfg.RuntimeThresholdFn != nil {
+		c.cfg.RuntimeThresholdFn(runtime)
+	}
+	c.belowRuntime = belowRuntime
+}
+
+// runPending runs the commands that failed when the previous connection was
+// lost.
+func (c *Client) runPending(conn net.Conn, pending *[]*cmdRequest) error {
+	for len(*pending) > 0 {
+		r := (*pending)[0]
+		*pending = (*pending)[1:]
+		if err := c.handleRequest(conn, r, pending); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rejectPending responds to any commands still waiting to be retried when the
+// client is closed.
+func rejectPending(pending []*cmdRequest) {
+	for _, r := range pending {
+		r.responseChan <- &cmdResponse{err: errNotConnected}
+	}
+}
+
+func (c *Client) loop(conn net.Conn) error {
+
+	// Clear the lastStatus on disconnect since it is now out of date
+	defer func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		c.lastStatus = nil
+	}()
+
+	// Any change in power held back on the previous connection is discarded
+	c.debounceChan = nil
 
-		// Wait for next poll interval
+	// Create the response reader for the session
+	l := &listReader{maxRows: c.cfg.getMaxListRows()}
+
+	// Retrieve the status immediately and then every n seconds until an error
+	// occurs, running any commands requested in the meantime
+	if err := c.poll(conn, l); err != nil {
+		return err
+	}
+	if err := c.runPending(conn, &c.pending); err != nil {
+		return err
+	}
+	ticker := c.clock.NewTicker(c.cfg.getPollInterval())
+	defer ticker.Stop()
+	for {
 		select {
-		case <-time.After(c.cfg.getPollInterval()):
+		case <-ticker.C():
+			if err := c.poll(conn, l); err != nil {
+				return err
+			}
+		case <-c.debounceChan:
+			c.applyPending()
+		case r := <-c.requestChan:
+			if err := c.handleRequest(conn, r, &c.pending); err != nil {
+				c.logf("command failed: %s", err)
+				return err
+			}
 		case <-c.ctx.Done():
+			c.logout(conn)
 			conn.Close()
 			return context.Canceled
 		}
 	}
 }
 
-func (c *Client) lifecycle() error {
+func (c *Client) startTLS(conn net.Conn, addr string) (net.Conn, error) {
+	if c.cfg.TLS == nil {
+		return conn, nil
+	}
+	if _, err := c.runCmd(conn, []string{"STARTTLS"}); err != nil {
+		return conn, err
+	}
+	cfg := c.cfg.TLS
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName = getHost(addr)
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, c.cfg.getCommandTimeout())
+	defer cancel()
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return conn, err
+	}
+	return tlsConn, nil
+}
 
-	dialer := &net.Dialer{
-		Timeout: c.cfg.ReconnectInterval,
+// authenticate sends USERNAME followed by PASSWORD, waiting for each to be
+// acknowledged, since upsd rejects PASSWORD before USERNAME. The error
+// returned by the server (such as ErrAccessDenied) can be checked with
+// errors.Is.
+func (c *Client) authenticate(conn net.Conn) error {
+	if c.cfg.Username == "" {
+		return nil
+	}
+	for _, args := range [][]string{
+		{"USERNAME", c.cfg.Username},
+		{"PASSWORD", c.cfg.Password},
+	} {
+		if _, err := c.runCmd(conn, args); err != nil {
+			return fmt.Errorf("authentication failed: %s: %w", args[0], err)
+		}
 	}
+	return nil
+}
+
+func (c *Client) login(conn net.Conn) error {
+	if c.cfg.LoginUPS == "" {
+		return nil
+	}
+	_, err := c.runCmd(conn, []string{"LOGIN", c.cfg.LoginUPS})
+	return err
+}
+
+// logout ends the session before the connection is closed if the client
+// logged in to a UPS; errors are ignored since the connection is being closed
+// regardless
+func (c *Client) logout(conn net.Conn) {
+	if c.cfg.LoginUPS == "" {
+		return
+	}
+	conn.SetDeadline(time.Now().Add(logoutTimeout))
+	if _, err := conn.Write([]byte("LOGOUT\n")); err != nil {
+		return
+	}
+	(&lineReader{}).parse(conn)
+}
+
+func (c *Client) dial(network, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, c.cfg.getDialTimeout())
+	defer cancel()
+	var (
+		conn net.Conn
+		err  error
+	)
+	if c.cfg.DialContext != nil {
+		conn, err = c.cfg.DialContext(ctx, network, addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+	if err != nil {
+		return nil, &ConnectError{Addr: addr, Err: err}
+	}
+	if err := setKeepAlive(conn, c.cfg.TCPKeepAlive); err != nil {
+		conn.Close()
+		return nil, &ConnectError{Addr: addr, Err: err}
+	}
+	return conn, nil
+}
+
+// connect dials each of the servers in turn, beginning with the one at index
+// (the last one connected to), and returns the connection and address of the
+// first that succeeds, updating index to match. If none succeed, the error
+// from the last one is returned.
+func (c *Client) connect(index *int) (net.Conn, string, error) {
+	addrs := c.cfg.getAddrs()
+	var err error
+	for i := range addrs {
+		var (
+			n             = (*index + i) % len(addrs)
+			network, addr = splitNetworkAddr(addrs[n])
+			conn          net.Conn
+		)
+		c.logf("connecting to %s", addr)
+		conn, err = c.dial(network, addr)
+		if err != nil {
+			c.logf("%s", err)
+			continue
+		}
+		*index = n
+		return conn, addrs[n], nil
+	}
+	return nil, "", err
+}
+
+// keepAliveConn is implemented by connections that support TCP keepalive
+// probes, such as *net.TCPConn.
+type keepAliveConn interface {
+	SetKeepAlive(bool) error
+	SetKeepAlivePeriod(time.Duration) error
+}
+
+// setKeepAlive enables TCP keepalive probes with the specified period. Other
+// types of connection (such as Unix domain sockets) are left unchanged.
+func setKeepAlive(conn net.Conn, d time.Duration) error {
+	tcpConn, ok := conn.(keepAliveConn)
+	if d <= 0 || !ok {
+		return nil
+	}
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		return err
+	}
+	return tcpConn.SetKeepAlivePeriod(d)
+}
+
+// probe reports the version of the server to ServerInfoFn; if the server
+// responds to either command with an error, the probe is skipped
+func (c *Client) probe(conn net.Conn) error {
+	if !c.cfg.OnConnectProbe || c.cfg.ServerInfoFn == nil {
+		return nil
+	}
+	version, err := c.runRaw(conn, []string{"VER"})
+	if err != nil {
+		return ignoreProtocolError(err)
+	}
+	protocol, err := c.runRaw(conn, []string{"PROTVER"})
+	if err != nil {
+		return ignoreProtocolError(err)
+	}
+	c.cfg.ServerInfoFn(version, protocol)
+	return nil
+}
+
+// setConnected records the address of the server when a connection is
+// established and clears it when the connection ends.
+func (c *Client) setConnected(addr net.Addr) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.remoteAddr = addr
+	if addr != nil {
+		c.stats.ConnectCount++
+		c.connectedAt = c.clock.Now()
+	} else {
+		c.connectedAt = time.Time{}
+	}
+}
+
+func (c *Client) lifecycle() error {
 
 	// Connect to the server
-	conn, err := dialer.DialContext(c.ctx, "tcp", c.cfg.getAddr())
+	conn, addr, err := c.connect(&c.addrIndex)
 	if err != nil {
 		return err
 	}
+	c.setConnected(conn.RemoteAddr())
+	atomic.StoreInt32(&c.connected, 1)
+	defer func() {
+		atomic.StoreInt32(&c.connected, 0)
+		c.setConnected(nil)
+		conn.Close()
+	}()
 
 	// Connected; invoke the callback if specified
+	c.logf("connected to %s", addr)
+	c.emit(Connected)
 	if c.cfg.ConnectedFn != nil {
 		c.cfg.ConnectedFn()
 	}
+	if !c.disconnectedAt.IsZero() {
+		downtime := c.clock.Now().Sub(c.disconnectedAt)
+		c.disconnectedAt = time.Time{}
+		if c.cfg.ReconnectedFn != nil {
+			c.cfg.ReconnectedFn(downtime)
+		}
+	}
 
-	// Run the loop until an error is encountered - either the context is
-	// canceled or the client was disconnected
-	err = c.loop(conn)
-	if err != context.Canceled && c.cfg.DisconnectedFn != nil {
-		c.cfg.DisconnectedFn()
+	// Upgrade to TLS, authenticate, log in to the UPS, and probe the server
+	// (if configured) and then run the loop until an error is encountered -
+	// either the context is canceled or the client was disconnected
+	conn, err = c.startTLS(conn, addr)
+	if err == nil {
+		err = c.authenticate(conn)
+	}
+	if err == nil {
+		err = c.login(conn)
+	}
+	if err == nil {
+		err = c.probe(conn)
+	}
+	if err == nil {
+
+		// The session was established; only now reset the reconnect interval
+		// and failure count so that a server rejecting the credentials is
+		// retried with increasing intervals and eventually given up on
+		c.backoff.reset()
+		c.failures = 0
+		err = c.loop(conn)
+	}
+	if !errors.Is(err, context.Canceled) {
+		c.logf("disconnected: %s", err)
+		c.disconnectedAt = c.clock.Now()
+		func() {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			c.stats.DisconnectCount++
+		}()
+		c.emit(Disconnected)
+		if c.cfg.DisconnectedFn != nil {
+			c.cfg.DisconnectedFn()
+		}
+		if c.cfg.DisconnectedErrFn != nil {
+			c.cfg.DisconnectedErrFn(err)
+		}
 	}
 	return err
 }
@@ -166,32 +861,204 @@ func (c *Client) run() {
 	// - if disconnected, reconnect after a few seconds
 
 	defer close(c.closedChan)
+	defer close(c.eventChan)
+	defer func() {
+		rejectPending(c.pending)
+	}()
+
+	// Spread out the first connection attempt if configured to do so
+	if d := c.backoff.startup(); d > 0 {
+		c.logf("connecting in %s", d)
+		if !c.wait(d) {
+			return
+		}
+	}
+
 	for {
-		if err := c.lifecycle(); err == context.Canceled {
+		err := c.lifecycle()
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+
+		// Report the error that caused the connection attempt to fail or the
+		// connection to be lost
+		c.failures++
+		if c.cfg.ErrorFn != nil {
+			c.cfg.ErrorFn(err, c.failures)
+		}
+
+		// Give up if there have been too many failures, shutting down the
+		// rest of the client as well
+		if c.cfg.MaxReconnectAttempts > 0 && c.failures >= c.cfg.MaxReconnectAttempts {
+			c.logf("giving up after %d failures", c.failures)
+			c.cancel()
+			if c.cfg.GaveUpFn != nil {
+				c.cfg.GaveUpFn(err)
+			}
+			return
+		}
+
+		// Retry the connection after an increasing interval
+		d := c.backoff.next()
+		c.logf("reconnecting in %s", d)
+		if !c.wait(d) {
 			return
 		}
+	}
+}
+
+// serve runs commands on one of the additional connections in the pool until
+// an error is encountered.
+func (c *Client) serve(b *backoff, addrIndex *int, pending *[]*cmdRequest) error {
+	conn, addr, err := c.connect(addrIndex)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn, err = c.startTLS(conn, addr)
+	if err == nil {
+		err = c.authenticate(conn)
+	}
+	if err == nil {
+		b.reset()
+		err = c.runPending(conn, pending)
+	}
+	for err == nil {
+		select {
+		case r := <-c.poolChan:
+			err = c.handleRequest(conn, r, pending)
+		case <-c.ctx.Done():
+			err = context.Canceled
+		}
+	}
+	return err
+}
 
-		// Retry the connection every 30 seconds
+// runPool maintains one of the additional connections in the pool. Unlike
+// run, no commands are rejected while waiting to reconnect since the other
+// connections may still be able to run them.
+func (c *Client) runPool() {
+	var (
+		b         = newBackoff(c.cfg)
+		addrIndex int
+		pending   = []*cmdRequest{}
+	)
+	defer func() {
+		rejectPending(pending)
+	}()
+	for {
+		err := c.serve(b, &addrIndex, &pending)
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		c.logf("pool connection failed: %s", err)
 		select {
-		case <-time.After(c.cfg.getReconnectInterval()):
+		case <-c.clock.After(b.next()):
 		case <-c.ctx.Done():
 			return
 		}
 	}
 }
 
+// wait blocks for the specified duration, rejecting any commands requested in
+// the meantime. false is returned if the client was closed.
+func (c *Client) wait(d time.Duration) bool {
+	t := c.clock.After(d)
+	for {
+		select {
+		case <-t:
+			return true
+		case r := <-c.requestChan:
+			r.responseChan <- &cmdResponse{err: errNotConnected}
+		case <-c.ctx.Done():
+			return false
+		}
+	}
+}
+
+// send passes a command to the goroutine that owns the connection and waits
+// for the response.
+func (c *Client) send(cmdType cmdType, args ...string) (any, error) {
+	return c.sendContext(context.Background(), cmdType, args...)
+}
+
+// submit passes a request to the goroutine that owns a connection without
+// waiting for the response.
+func (c *Client) submit(r *cmdRequest) error {
+	if c.cfg.ReadOnly && (r.cmdType == typeCmd || r.cmdType == typeSet) {
+		return ErrReadOnlyClient
+	}
+
+	// LOGOUT must be sent on the connection that logged in, so it is never
+	// passed to the pool (poolChan is nil if there is no pool)
+	poolChan := c.poolChan
+	if r.cmdType == typeLogout {
+		poolChan = nil
+	}
+	select {
+	case c.requestChan <- r:
+		return nil
+	case poolChan <- r:
+		return nil
+	case <-c.closedChan:
+		return errNotConnected
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	}
+}
+
+// sendContext is identical to send but stops waiting when ctx is done. The
+// command is skipped if it has not yet been sent to the server.
+func (c *Client) sendContext(ctx context.Context, cmdType cmdType, args ...string) (any, error) {
+	r := &cmdRequest{
+		ctx:          ctx,
+		cmdType:      cmdType,
+		args:         args,
+		responseChan: make(chan *cmdResponse, 1),
+	}
+	if err := c.submit(r); err != nil {
+		return nil, err
+	}
+	select {
+	case v := <-r.responseChan:
+		return v.v, v.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func newClient(cfg *Config, clock clock) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	var poolChan chan *cmdRequest
+	if cfg.PoolSize > 1 {
+		poolChan = make(chan *cmdRequest)
+	}
+	return &Client{
+		name:        cfg.getName(),
+		cfg:         cfg,
+		clock:       clock,
+		backoff:     newBackoff(cfg),
+		watchedVars: map[string]string{},
+		ctx:         ctx,
+		cancel:      cancel,
+		requestChan: make(chan *cmdRequest),
+		poolChan:    poolChan,
+		eventChan:   make(chan Event, eventBufferSize),
+		closedChan:  make(chan any),
+	}
+}
+
 // New creates a new Client instance for the specified server.
 func New(cfg *Config) *Client {
-	var (
-		ctx, cancel = context.WithCancel(context.Background())
-		c           = &Client{
-			cfg:        cfg,
-			ctx:        ctx,
-			cancel:     cancel,
-			closedChan: make(chan any),
-		}
-	)
+	c := newClient(cfg, realClock{})
 	go c.run()
+	for i := 1; i < cfg.PoolSize; i++ {
+		c.poolWait.Add(1)
+		go func() {
+			defer c.poolWait.Done()
+			c.runPool()
+		}()
+	}
 	return c
 }
 
@@ -209,9 +1076,56 @@ func (c *Client) Status() map[string]string {
 	return lastStatus
 }
 
+// OnBattery returns true if the UPS was running on battery the last time it
+// was polled. The raw value of ups.status is available from Status.
+func (c *Client) OnBattery() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.onBattery
+}
+
+// IsConnected returns true if the client is currently connected to the
+// server.
+func (c *Client) IsConnected() bool {
+	return atomic.LoadInt32(&c.connected) == 1
+}
+
+// SetName changes the UPS that is monitored. The state of the previous UPS is
+// discarded when the status is next polled, so the callbacks for the new UPS
+// are invoked as if the client had just connected.
+func (c *Client) SetName(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.name = name
+	c.rebaseline = true
+}
+
+// RemoteAddr returns the address of the server that the client is connected
+// to, which is useful when Addr resolves to more than one address or Addrs
+// specifies fallback servers. nil is returned if the client is not connected.
+func (c *Client) RemoteAddr() net.Addr {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.remoteAddr
+}
+
+// CloseContext shuts down the client after sending LOGOUT to the server and
+// waiting for it to acknowledge, which avoids the server logging an abrupt
+// disconnection. If ctx is done before the server responds, the connection
+// is closed immediately, as with Close, and ctx.Err() is returned.
+func (c *Client) CloseContext(ctx context.Context) error {
+	_, err := c.sendContext(ctx, typeLogout, "LOGOUT")
+	c.Close()
+	if err == errNotConnected {
+		return nil
+	}
+	return err
+}
+
 // Close shuts down the client. It is guaranteed that no more callbacks will be
 // invoked after this method returns.
 func (c *Client) Close() {
 	c.cancel()
 	<-c.closedChan
+	c.poolWait.Wait()
 }
@@ -0,0 +1,2146 @@
+package nutclient
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testStatus = `BEGIN LIST VAR ups
+VAR ups ups.status "OL"
+END LIST VAR ups`
+
+// newTestServer creates a server that replies to each command with the
+// matching response, or ERR UNKNOWN-COMMAND if there is none.
+func newTestServer(t *testing.T, responses map[string]string) string {
+	return newTestTLSServer(t, responses, nil)
+}
+
+// newTestTLSServer creates a test server that upgrades the connection with
+// the provided TLS configuration when STARTTLS is received.
+func newTestTLSServer(t *testing.T, responses map[string]string, cfg *tls.Config) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestConn(conn, responses, cfg)
+		}
+	}()
+	return l.Addr().String()
+}
+
+// newTestStatusServer creates a server that replies to each LIST VAR ups with
+// the next of the provided listings, repeating the last one once they have
+// all been sent.
+func newTestStatusServer(t *testing.T, listings ...string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	var (
+		mutex sync.Mutex
+		n     int
+	)
+	next := func() string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		v := listings[n]
+		if n < len(listings)-1 {
+			n++
+		}
+		return v
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				s := bufio.NewScanner(conn)
+				for s.Scan() {
+					r := "ERR UNKNOWN-COMMAND"
+					if s.Text() == "LIST VAR ups" {
+						r = next()
+					}
+					if _, err := conn.Write([]byte(r + "\n")); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// testListing returns the response to LIST VAR ups for the provided status.
+func testListing(status string) string {
+	return fmt.Sprintf("BEGIN LIST VAR ups\nVAR ups ups.status %q\nEND LIST VAR ups", status)
+}
+
+func serveTestConn(conn net.Conn, responses map[string]string, cfg *tls.Config) {
+	defer conn.Close()
+	s := bufio.NewScanner(conn)
+	for s.Scan() {
+		if s.Text() == "STARTTLS" && cfg != nil {
+			if _, err := conn.Write([]byte("OK STARTTLS\n")); err != nil {
+				return
+			}
+			tlsConn := tls.Server(conn, cfg)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			serveTestConn(tlsConn, responses, nil)
+			return
+		}
+		r, ok := responses[s.Text()]
+		if !ok {
+			r = "ERR UNKNOWN-COMMAND"
+		}
+		if _, err := conn.Write([]byte(r + "\n")); err != nil {
+			return
+		}
+	}
+}
+
+// newTestCertificate creates a self-signed certificate for 127.0.0.1 and a
+// pool containing it.
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+	}
+	b, err := x509.CreateCertificate(rand.Reader, template, template, &k.PublicKey, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{
+		Certificate: [][]byte{b},
+		PrivateKey:  k,
+		Leaf:        cert,
+	}, pool
+}
+
+// newTestClient creates a client for the server and waits for it to connect
+// for the first time.
+func newTestClient(t *testing.T, cfg *Config) *Client {
+	var (
+		connectedChan = make(chan any)
+		once          sync.Once
+	)
+	cfg.ConnectedFn = func() {
+		once.Do(func() {
+			close(connectedChan)
+		})
+	}
+	c := New(cfg)
+	t.Cleanup(c.Close)
+	<-connectedChan
+	return c
+}
+
+func TestList(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": testStatus,
+			"LIST UPS": `BEGIN LIST UPS
+UPS ups "Test UPS"
+END LIST UPS`,
+		}),
+	})
+	rows, err := c.List("UPS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := [][]string{{"ups", "Test UPS"}}; !reflect.DeepEqual(v, rows) {
+		t.Fatalf("%#v != %#v", v, rows)
+	}
+	if _, err := c.List("CMD", "ups"); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestRunCmd(t *testing.T) {
+	for _, v := range []struct {
+		name     string
+		response string
+		output   []string
+		err      bool
+	}{
+		{
+			name:     "OK",
+			response: "OK",
+			output:   []string{},
+		},
+		{
+			name:     "OK with detail",
+			response: "OK Done",
+			output:   []string{"Done"},
+		},
+		{
+			name:     "lowercase",
+			response: "ok",
+			output:   []string{},
+		},
+		{
+			name:     "server error",
+			response: "ERR ACCESS-DENIED",
+			err:      true,
+		},
+		{
+			name:     "unexpected response",
+			response: "NOT OK",
+			err:      true,
+		},
+	} {
+		c1, c2 := net.Pipe()
+		go serveTestConn(c2, map[string]string{
+			"CMD": v.response,
+		}, nil)
+		output, err := newClient(&Config{}, realClock{}).runCmd(c1, []string{"CMD"})
+		c1.Close()
+		if err != nil {
+			if !v.err {
+				t.Fatalf("%s: %s", v.name, err)
+			}
+			continue
+		}
+		if v.err {
+			t.Fatalf("%s: error expected", v.name)
+		}
+		if !reflect.DeepEqual(v.output, output) {
+			t.Fatalf("%s: %#v != %#v", v.name, v.output, output)
+		}
+	}
+}
+
+func TestInstCmd(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups":                   testStatus,
+			"INSTCMD ups test.battery.start": "OK",
+			"INSTCMD ups test.battery.stop":  "ERR CMD-NOT-SUPPORTED",
+		}),
+	})
+	if err := c.InstCmd("ups", "test.battery.start"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.InstCmd("ups", "test.battery.stop"); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestSet(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups":                      testStatus,
+			`SET VAR ups ups.id "my ups"`:       "OK",
+			`SET VAR ups ups.mfr "test"`:        "ERR READONLY",
+			`SET VAR ups ups.delay.start "120"`: "ERR ACCESS-DENIED",
+			`SET VAR ups ups.contact "\"IT\""`:  "OK",
+		}),
+	})
+	for _, v := range []struct {
+		name  string
+		value string
+		err   error
+	}{
+		{name: "ups.id", value: "my ups"},
+		{name: "ups.mfr", value: "test", err: ErrReadOnly},
+		{name: "ups.delay.start", value: "120", err: ErrAccessDenied},
+		{name: "ups.contact", value: `"IT"`},
+	} {
+		if err := c.Set("ups", v.name, v.value); !errors.Is(err, v.err) {
+			t.Fatalf("%s: %#v != %#v", v.name, v.err, err)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups":                   testStatus,
+			"GET VAR ups ups.id":             `VAR ups ups.id "my ups"`,
+			`SET VAR ups ups.id "new name"`:  "OK",
+			"INSTCMD ups test.battery.start": "OK",
+			"PRIMARY ups":                    "OK",
+			"FSD ups":                        "OK",
+		}),
+		ReadOnly: true,
+	})
+	for name, fn := range map[string]func() error{
+		"Set":     func() error { return c.Set("ups", "ups.id", "new name") },
+		"InstCmd": func() error { return c.InstCmd("ups", "test.battery.start") },
+		"Primary": func() error { return c.Primary("ups") },
+		"FSD":     func() error { return c.FSD("ups") },
+	} {
+		if err := fn(); !errors.Is(err, ErrReadOnlyClient) {
+			t.Fatalf("%s: %#v != %#v", name, ErrReadOnlyClient, err)
+		}
+	}
+	if _, err := c.GetVar("ups", "ups.id"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListUPS(t *testing.T) {
+	for _, v := range []struct {
+		name     string
+		response string
+		output   []UPSInfo
+	}{
+		{
+			name: "empty list",
+			response: `BEGIN LIST UPS
+END LIST UPS`,
+			output: []UPSInfo{},
+		},
+		{
+			name: "two UPS",
+			response: `BEGIN LIST UPS
+UPS ups1 "First UPS"
+UPS ups2 "Second UPS"
+END LIST UPS`,
+			output: []UPSInfo{
+				{Name: "ups1", Description: "First UPS"},
+				{Name: "ups2", Description: "Second UPS"},
+			},
+		},
+		{
+			name: "missing descriptions",
+			response: `BEGIN LIST UPS
+UPS ups1 "First UPS"
+UPS ups2
+UPS ups3 ""
+END LIST UPS`,
+			output: []UPSInfo{
+				{Name: "ups1", Description: "First UPS"},
+				{Name: "ups2"},
+				{Name: "ups3"},
+			},
+		},
+	} {
+		c := newTestClient(t, &Config{
+			Addr: newTestServer(t, map[string]string{
+				"LIST VAR ups": testStatus,
+				"LIST UPS":     v.response,
+			}),
+		})
+		upsList, err := c.ListUPS()
+		if err != nil {
+			t.Fatalf("%s: %s", v.name, err)
+		}
+		if !reflect.DeepEqual(v.output, upsList) {
+			t.Fatalf("%s: %#v != %#v", v.name, v.output, upsList)
+		}
+	}
+}
+
+func TestListAll(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": testStatus,
+			"LIST UPS": `BEGIN LIST UPS
+UPS ups "First UPS"
+UPS ups2 "Second UPS"
+END LIST UPS`,
+			"LIST VAR ups2": "ERR DRIVER-NOT-CONNECTED",
+		}),
+	})
+	all, err := c.ListAll()
+	var listErr *ListAllError
+	if !errors.As(err, &listErr) {
+		t.Fatalf("%#v is not a ListAllError", err)
+	}
+	if !errors.Is(listErr.Errors["ups2"], &ProtocolError{Code: "DRIVER-NOT-CONNECTED"}) {
+		t.Fatalf("unexpected error %#v", listErr.Errors["ups2"])
+	}
+	if !errors.Is(err, ErrDriverNotConnected) {
+		t.Fatalf("%#v != %#v", ErrDriverNotConnected, err)
+	}
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) || protocolErr.Code != "DRIVER-NOT-CONNECTED" {
+		t.Fatalf("%#v is not a ProtocolError", err)
+	}
+	v := map[string]map[string]string{
+		"ups": {"ups.status": "OL"},
+	}
+	if !reflect.DeepEqual(v, all) {
+		t.Fatalf("%#v != %#v", v, all)
+	}
+}
+
+func TestListVars(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": `BEGIN LIST VAR ups
+VAR ups ups.status "OL"
+VAR ups ups.mfr "American Power Conversion"
+END LIST VAR ups`,
+		}),
+	})
+	variables, err := c.ListVars("ups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := map[string]string{
+		"ups.status": "OL",
+		"ups.mfr":    "American Power Conversion",
+	}
+	if !reflect.DeepEqual(v, variables) {
+		t.Fatalf("%#v != %#v", v, variables)
+	}
+}
+
+func TestListVarRows(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": `BEGIN LIST VAR ups
+VAR ups ups.status "OL"
+VAR ups ups.mfr "American Power Conversion"
+END LIST VAR ups`,
+		}),
+	})
+	rows, err := c.ListVarRows("ups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := []VarRow{
+		{Name: "ups.status", Value: "OL"},
+		{Name: "ups.mfr", Value: "American Power Conversion"},
+	}
+	if !reflect.DeepEqual(v, rows) {
+		t.Fatalf("%#v != %#v", v, rows)
+	}
+}
+
+func TestListCommands(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": testStatus,
+			"LIST CMD ups": `BEGIN LIST CMD ups
+CMD ups beeper.disable
+CMD ups test.battery.start
+END LIST CMD ups`,
+		}),
+	})
+	commands, err := c.ListCommands("ups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := []string{"beeper.disable", "test.battery.start"}; !reflect.DeepEqual(v, commands) {
+		t.Fatalf("%#v != %#v", v, commands)
+	}
+}
+
+func TestListRW(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": testStatus,
+			"LIST RW ups": `BEGIN LIST RW ups
+END LIST RW ups`,
+		}),
+	})
+	variables, err := c.ListRW("ups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := map[string]string{}; !reflect.DeepEqual(v, variables) {
+		t.Fatalf("%#v != %#v", v, variables)
+	}
+	if _, err := c.ListRW("ups2"); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestListEnum(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": testStatus,
+			"LIST ENUM ups ups.beeper.status": `BEGIN LIST ENUM ups ups.beeper.status
+ENUM ups ups.beeper.status "enabled"
+ENUM ups ups.beeper.status "disabled until reset"
+ENUM ups ups.beeper.status "muted \"temporarily\""
+END LIST ENUM ups ups.beeper.status`,
+		}),
+	})
+	values, err := c.ListEnum("ups", "ups.beeper.status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := []string{"enabled", "disabled until reset", `muted "temporarily"`}
+	if !reflect.DeepEqual(v, values) {
+		t.Fatalf("%#v != %#v", v, values)
+	}
+}
+
+func TestListRange(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": testStatus,
+			"LIST RANGE ups input.transfer.low": `BEGIN LIST RANGE ups input.transfer.low
+RANGE ups input.transfer.low "90" "100"
+RANGE ups input.transfer.low "105" "110"
+END LIST RANGE ups input.transfer.low`,
+			"LIST RANGE ups ups.id": `BEGIN LIST RANGE ups ups.id
+END LIST RANGE ups ups.id`,
+		}),
+	})
+	for _, v := range []struct {
+		name   string
+		output []Range
+	}{
+		{
+			name: "input.transfer.low",
+			output: []Range{
+				{Min: "90", Max: "100"},
+				{Min: "105", Max: "110"},
+			},
+		},
+		{
+			name:   "ups.id",
+			output: []Range{},
+		},
+	} {
+		ranges, err := c.ListRange("ups", v.name)
+		if err != nil {
+			t.Fatalf("%s: %s", v.name, err)
+		}
+		if !reflect.DeepEqual(v.output, ranges) {
+			t.Fatalf("%s: %#v != %#v", v.name, v.output, ranges)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups":                testStatus,
+			"GET VAR ups battery.charge":  `VAR ups battery.charge "100"`,
+			"GET DESC ups battery.charge": `DESC ups battery.charge "Battery charge (percent)"`,
+			"GET VAR ups battery.runtime": `VAR ups battery.charge "100"`,
+			"GET VAR ups battery.voltage": `ERR VAR-NOT-SUPPORTED`,
+		}),
+	})
+	for _, v := range []struct {
+		name   string
+		args   []string
+		output string
+		err    bool
+	}{
+		{
+			name:   "variable",
+			args:   []string{"VAR", "ups", "battery.charge"},
+			output: "100",
+		},
+		{
+			name:   "description",
+			args:   []string{"DESC", "ups", "battery.charge"},
+			output: "Battery charge (percent)",
+		},
+		{
+			name: "server error",
+			args: []string{"VAR", "ups", "battery.voltage"},
+			err:  true,
+		},
+		{
+			name: "mismatched response",
+			args: []string{"VAR", "ups", "battery.runtime"},
+			err:  true,
+		},
+	} {
+		output, err := c.Get(v.args...)
+		if err != nil {
+			if !v.err {
+				t.Fatalf("%s: %s", v.name, err)
+			}
+		} else {
+			if v.output != output {
+				t.Fatalf("%s: %#v != %#v", v.name, v.output, output)
+			}
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	const banner = `Network UPS Tools upsd 2.8.0 - http://www.networkupstools.org/`
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": testStatus,
+			"VER":          banner,
+		}),
+	})
+	v, err := c.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != banner {
+		t.Fatalf("%#v != %#v", banner, v)
+	}
+}
+
+func TestSupportedCommands(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": testStatus,
+			"HELP":         "Commands: HELP VER GET LIST LOGOUT",
+		}),
+	})
+	v, err := c.SupportedCommands()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := []string{"HELP", "VER", "GET", "LIST", "LOGOUT"}
+	if !reflect.DeepEqual(commands, v) {
+		t.Fatalf("%#v != %#v", commands, v)
+	}
+}
+
+func TestPing(t *testing.T) {
+	for _, v := range []struct {
+		name      string
+		responses map[string]string
+	}{
+		{
+			name: "version",
+			responses: map[string]string{
+				"LIST VAR ups": testStatus,
+				"VER":          "Network UPS Tools upsd 2.8.0",
+			},
+		},
+		{
+			name: "server error",
+			responses: map[string]string{
+				"LIST VAR ups": testStatus,
+			},
+		},
+	} {
+		c := newTestClient(t, &Config{
+			Addr: newTestServer(t, v.responses),
+		})
+		if err := c.Ping(); err != nil {
+			t.Fatalf("%s: %s", v.name, err)
+		}
+	}
+	c := New(&Config{
+		Addr:              "127.0.0.1:1",
+		ReconnectInterval: time.Minute,
+	})
+	defer c.Close()
+	if err := c.Ping(); err == nil {
+		t.Fatal("error expected")
+	}
+}
+
+func TestProtocolVersion(t *testing.T) {
+	for _, v := range []struct {
+		name      string
+		responses map[string]string
+		command   string
+	}{
+		{
+			name: "PROTVER",
+			responses: map[string]string{
+				"LIST VAR ups": testStatus,
+				"PROTVER":      "1.2",
+			},
+			command: "PROTVER",
+		},
+		{
+			name: "NETVER fallback",
+			responses: map[string]string{
+				"LIST VAR ups": testStatus,
+				"NETVER":       "1.2",
+			},
+			command: "NETVER",
+		},
+	} {
+		c := newTestClient(t, &Config{
+			Addr: newTestServer(t, v.responses),
+		})
+		version, command, err := c.ProtocolVersion()
+		if err != nil {
+			t.Fatalf("%s: %s", v.name, err)
+		}
+		if version != "1.2" {
+			t.Fatalf("%s: %#v != %#v", v.name, "1.2", version)
+		}
+		if command != v.command {
+			t.Fatalf("%s: %#v != %#v", v.name, v.command, command)
+		}
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": `BEGIN LIST VAR ups
+VAR ups ups.status "OL"
+VAR ups battery.charge "100"
+END LIST VAR ups`,
+			"GET VAR ups ups.id": `VAR ups ups.id "test"`,
+		}),
+	})
+	values, err := c.GetMany("ups", []string{"battery.charge", "ups.id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := map[string]string{
+		"battery.charge": "100",
+		"ups.id":         "test",
+	}
+	if !reflect.DeepEqual(v, values) {
+		t.Fatalf("%#v != %#v", v, values)
+	}
+}
+
+func TestTryGet(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups":               testStatus,
+			"GET VAR ups battery.charge": `VAR ups battery.charge "100"`,
+		}),
+	})
+	if _, err := c.TryGet("VAR", "ups", "battery.charge"); err != nil {
+		t.Fatal(err)
+	}
+	c = New(&Config{
+		Addr:              "127.0.0.1:1",
+		ReconnectInterval: time.Minute,
+	})
+	defer c.Close()
+	if _, err := c.TryGet("VAR", "ups", "battery.charge"); !errors.Is(err, errNotConnected) {
+		t.Fatalf("%#v != %#v", errNotConnected, err)
+	}
+}
+
+func TestGetVar(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups":                testStatus,
+			"GET VAR ups ups.mfr":         `VAR ups ups.mfr "American Power Conversion"`,
+			"GET VAR ups battery.voltage": `ERR VAR-NOT-SUPPORTED`,
+		}),
+	})
+	v, err := c.GetVar("ups", "ups.mfr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "American Power Conversion" {
+		t.Fatalf("%#v != %#v", "American Power Conversion", v)
+	}
+	if _, err := c.GetVar("ups", "battery.voltage"); !errors.Is(err, ErrVarNotSupported) {
+		t.Fatalf("%#v != %#v", ErrVarNotSupported, err)
+	}
+}
+
+func TestGetType(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups":            testStatus,
+			"GET TYPE ups ups.id":     "TYPE ups ups.id RW STRING:64",
+			"GET TYPE ups ups.status": "TYPE ups ups.status STRING:32",
+		}),
+	})
+	for _, v := range []struct {
+		name   string
+		output []string
+	}{
+		{name: "ups.id", output: []string{"RW", "STRING:64"}},
+		{name: "ups.status", output: []string{"STRING:32"}},
+	} {
+		output, err := c.GetType("ups", v.name)
+		if err != nil {
+			t.Fatalf("%s: %s", v.name, err)
+		}
+		if !reflect.DeepEqual(v.output, output) {
+			t.Fatalf("%s: %#v != %#v", v.name, v.output, output)
+		}
+	}
+}
+
+func TestGetCmdDesc(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups":                        testStatus,
+			"GET CMDDESC ups test.battery.start":  `CMDDESC ups test.battery.start "Start a battery test"`,
+			"GET CMDDESC ups test.battery.cancel": `ERR CMD-NOT-SUPPORTED`,
+		}),
+	})
+	v, err := c.GetCmdDesc("ups", "test.battery.start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "Start a battery test" {
+		t.Fatalf("%#v != %#v", "Start a battery test", v)
+	}
+	if _, err := c.GetCmdDesc("ups", "test.battery.cancel"); !errors.Is(err, ErrCmdNotSupported) {
+		t.Fatalf("%#v != %#v", ErrCmdNotSupported, err)
+	}
+}
+
+func TestGetUPSDesc(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups":    testStatus,
+			"GET UPSDESC ups": `UPSDESC ups "Server room \"rack 2\""`,
+		}),
+	})
+	v, err := c.GetUPSDesc("ups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != `Server room "rack 2"` {
+		t.Fatalf("%#v != %#v", `Server room "rack 2"`, v)
+	}
+}
+
+func TestGetNumLogins(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups":       testStatus,
+			"GET NUMLOGINS ups":  "NUMLOGINS ups 2",
+			"GET NUMLOGINS ups2": "NUMLOGINS ups2 abc",
+		}),
+	})
+	v, err := c.GetNumLogins("ups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 2 {
+		t.Fatalf("%#v != %#v", 2, v)
+	}
+	if _, err := c.GetNumLogins("ups2"); !errors.Is(err, strconv.ErrSyntax) {
+		t.Fatalf("%#v != %#v", strconv.ErrSyntax, err)
+	}
+}
+
+func TestForUPS(t *testing.T) {
+	var (
+		c = newTestClient(t, &Config{
+			Addr: newTestServer(t, map[string]string{
+				"LIST VAR ups":                    testStatus,
+				"GET VAR ups2 ups.status":         `VAR ups2 ups.status "OB"`,
+				`SET VAR ups2 ups.id "my ups"`:    "OK",
+				"INSTCMD ups2 test.battery.start": "OK",
+			}),
+		})
+		u = c.ForUPS("ups2")
+	)
+	status, err := u.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != "OB" {
+		t.Fatalf("%#v != %#v", "OB", status)
+	}
+	if err := u.Set("ups.id", "my ups"); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.InstCmd("test.battery.start"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetNumeric(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups":                testStatus,
+			"GET VAR ups input.voltage":   `VAR ups input.voltage "121.5"`,
+			"GET VAR ups battery.runtime": `VAR ups battery.runtime "1800"`,
+			"GET VAR ups ups.status":      `VAR ups ups.status "OL"`,
+		}),
+	})
+	if v, err := c.GetFloat("VAR", "ups", "input.voltage"); err != nil || v != 121.5 {
+		t.Fatalf("%#v != %#v (%v)", 121.5, v, err)
+	}
+	if v, err := c.GetInt("VAR", "ups", "battery.runtime"); err != nil || v != 1800 {
+		t.Fatalf("%#v != %#v (%v)", 1800, v, err)
+	}
+	if _, err := c.GetInt("VAR", "ups", "ups.status"); !errors.Is(err, strconv.ErrSyntax) {
+		t.Fatalf("%#v != %#v", strconv.ErrSyntax, err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	addr := newTestServer(t, map[string]string{
+		"USERNAME admin":    "OK",
+		"PASSWORD password": "OK",
+		"LIST VAR ups":      testStatus,
+	})
+	if c := newTestClient(t, &Config{
+		Addr:     addr,
+		Username: "admin",
+		Password: "password",
+	}); !c.IsConnected() {
+		t.Fatal("client should be connected")
+	}
+	var (
+		disconnectedChan = make(chan any)
+		c                = New(&Config{
+			Addr:     addr,
+			Username: "admin",
+			Password: "wrong",
+			DisconnectedFn: func() {
+				close(disconnectedChan)
+			},
+		})
+	)
+	defer c.Close()
+	<-disconnectedChan
+	c.Close()
+	if c.IsConnected() {
+		t.Fatal("client should not be connected")
+	}
+}
+
+// serveTestAuth replies to commands in the same way as upsd, which requires
+// USERNAME to be sent once before PASSWORD.
+func serveTestAuth(conn net.Conn, username, password string) {
+	defer conn.Close()
+	var (
+		s                 = bufio.NewScanner(conn)
+		gotUsername       string
+		gotPassword, auth bool
+	)
+	for s.Scan() {
+		var (
+			fields = strings.Fields(s.Text())
+			r      = "ERR UNKNOWN-COMMAND"
+		)
+		switch {
+		case len(fields) == 2 && fields[0] == "USERNAME":
+			if gotUsername != "" {
+				r = "ERR ALREADY-SET-USERNAME"
+			} else {
+				gotUsername = fields[1]
+				r = "OK"
+			}
+		case len(fields) == 2 && fields[0] == "PASSWORD":
+			switch {
+			case gotUsername == "":
+				r = "ERR USERNAME-REQUIRED"
+			case gotPassword:
+				r = "ERR ALREADY-SET-PASSWORD"
+			default:
+				gotPassword = true
+				auth = gotUsername == username && fields[1] == password
+				r = "OK"
+			}
+		case s.Text() == "LIST VAR ups":
+			r = testStatus
+		case s.Text() == "INSTCMD ups beeper.toggle":
+			r = "ERR ACCESS-DENIED"
+			if auth {
+				r = "OK"
+			}
+		}
+		if _, err := conn.Write([]byte(r + "\n")); err != nil {
+			return
+		}
+	}
+}
+
+func TestAuthenticateOrder(t *testing.T) {
+	for _, v := range []struct {
+		name     string
+		password string
+		err      error
+	}{
+		{name: "correct password", password: "password"},
+		{name: "wrong password", password: "wrong", err: ErrAccessDenied},
+	} {
+		c := newTestClient(t, &Config{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				c1, c2 := net.Pipe()
+				go serveTestAuth(c2, "admin", "password")
+				return c1, nil
+			},
+			Username: "admin",
+			Password: v.password,
+		})
+		if err := c.InstCmd("ups", "beeper.toggle"); !errors.Is(err, v.err) {
+			t.Fatalf("%s: %#v != %#v", v.name, v.err, err)
+		}
+	}
+}
+
+func TestStartTLS(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+	c := newTestClient(t, &Config{
+		Addr: newTestTLSServer(
+			t,
+			map[string]string{
+				"LIST VAR ups": testStatus,
+				"VER":          "upsd",
+			},
+			&tls.Config{Certificates: []tls.Certificate{cert}},
+		),
+		TLS: &tls.Config{RootCAs: pool},
+	})
+	if _, err := c.Version(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartTLSTimeout(t *testing.T) {
+	errChan := make(chan error, 1)
+	c := New(&Config{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c1, c2 := net.Pipe()
+			go func() {
+				defer c2.Close()
+
+				// Acknowledge STARTTLS but never begin the handshake
+				s := bufio.NewScanner(c2)
+				if s.Scan() {
+					c2.Write([]byte("OK STARTTLS\n"))
+				}
+				for s.Scan() {
+				}
+			}()
+			return c1, nil
+		},
+		TLS:               &tls.Config{},
+		CommandTimeout:    50 * time.Millisecond,
+		ReconnectInterval: time.Minute,
+		ErrorFn: func(err error, failures int) {
+			errChan <- err
+		},
+	})
+	defer c.Close()
+	select {
+	case err := <-errChan:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("%#v != %#v", context.DeadlineExceeded, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handshake did not time out")
+	}
+}
+
+func TestStartTLSClientCertificate(t *testing.T) {
+	var (
+		cert, pool = newTestCertificate(t)
+		addr       = newTestTLSServer(
+			t,
+			map[string]string{
+				"LIST VAR ups": testStatus,
+				"VER":          "upsd",
+			},
+			&tls.Config{
+				Certificates: []tls.Certificate{cert},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    pool,
+			},
+		)
+	)
+	for _, v := range []struct {
+		name string
+		cfg  *tls.Config
+	}{
+		{
+			name: "Certificates",
+			cfg: &tls.Config{
+				RootCAs:      pool,
+				Certificates: []tls.Certificate{cert},
+			},
+		},
+		{
+			name: "GetClientCertificate",
+			cfg: &tls.Config{
+				RootCAs: pool,
+				GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					return &cert, nil
+				},
+			},
+		},
+	} {
+		c := newTestClient(t, &Config{
+			Addr: addr,
+			TLS:  v.cfg,
+		})
+		if _, err := c.Version(); err != nil {
+			t.Fatalf("%s: %s", v.name, err)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LOGIN ups":         "OK",
+			"LIST VAR ups":      testStatus,
+			"GET NUMLOGINS ups": "NUMLOGINS ups 1",
+		}),
+		LoginUPS: "ups",
+	})
+	if v, err := c.GetNumLogins("ups"); err != nil || v != 1 {
+		t.Fatalf("%#v != %#v (%v)", 1, v, err)
+	}
+}
+
+func TestPrimary(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": testStatus,
+			"MASTER ups":   "OK MASTER-GRANTED",
+			"MASTER ups2":  "ERR ACCESS-DENIED",
+			"PRIMARY ups3": "ERR ACCESS-DENIED",
+			"MASTER ups3":  "OK MASTER-GRANTED",
+		}),
+	})
+	if err := c.Primary("ups"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Primary("ups2"); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("%#v != %#v", ErrAccessDenied, err)
+	}
+
+	// MASTER must not be tried if PRIMARY was recognized but refused
+	if err := c.Primary("ups3"); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("%#v != %#v", ErrAccessDenied, err)
+	}
+}
+
+func TestProtocolError(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups":               testStatus,
+			"GET VAR ups battery.charge": "ERR VAR-NOT-SUPPORTED",
+			"GET VAR ups2 ups.status":    "ERR DATA-STALE",
+		}),
+	})
+	if _, err := c.Get("VAR", "ups", "battery.charge"); !errors.Is(err, ErrVarNotSupported) {
+		t.Fatalf("%#v != %#v", ErrVarNotSupported, err)
+	}
+	_, err := c.Get("VAR", "ups2", "ups.status")
+	var p *ProtocolError
+	if !errors.As(err, &p) || p.Code != "DATA-STALE" {
+		t.Fatalf("%#v != %#v", "DATA-STALE", err)
+	}
+}
+
+func TestRemoteAddr(t *testing.T) {
+	var (
+		addr = newTestServer(t, map[string]string{
+			"LIST VAR ups": testStatus,
+		})
+		c = newTestClient(t, &Config{
+			Addr: addr,
+		})
+	)
+	if v := c.RemoteAddr(); v == nil || v.String() != addr {
+		t.Fatalf("%#v != %#v", addr, v)
+	}
+	c.Close()
+	if v := c.RemoteAddr(); v != nil {
+		t.Fatalf("%#v != nil", v)
+	}
+}
+
+func TestFallbackAddrs(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	var (
+		addr = newTestServer(t, map[string]string{
+			"LIST VAR ups": testStatus,
+		})
+		c = newTestClient(t, &Config{
+			Addr:  l.Addr().String(),
+			Addrs: []string{addr},
+		})
+	)
+	if v := c.RemoteAddr(); v == nil || v.String() != addr {
+		t.Fatalf("%#v != %#v", addr, v)
+	}
+}
+
+func TestConnectLastAddr(t *testing.T) {
+	var (
+		addrs = []string{}
+		c     = newClient(&Config{
+			Addrs: []string{"host1", "host2"},
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				addrs = append(addrs, addr)
+				if addr == "host1:3493" {
+					return nil, errors.New("connection refused")
+				}
+				c1, c2 := net.Pipe()
+				c2.Close()
+				return c1, nil
+			},
+		}, realClock{})
+	)
+	defer c.cancel()
+
+	// The second connection begins with the server that the first connected
+	// to, while a separate connection begins with the first server
+	var index, otherIndex int
+	for _, i := range []*int{&index, &index, &otherIndex} {
+		conn, _, err := c.connect(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	v := []string{
+		"host1:3493",
+		"host2:3493",
+		"host2:3493",
+		"host1:3493",
+		"host2:3493",
+	}
+	if !reflect.DeepEqual(v, addrs) {
+		t.Fatalf("%#v != %#v", v, addrs)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	var (
+		addr   = filepath.Join(t.TempDir(), "upsd.sock")
+		l, err = net.Listen("unix", addr)
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		serveTestConn(conn, map[string]string{
+			"LIST VAR ups": testStatus,
+		}, nil)
+	}()
+	newTestClient(t, &Config{
+		Addr: "unix://" + addr,
+	})
+}
+
+func TestGetContext(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": testStatus,
+		}),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.GetContext(ctx, "VAR", "ups", "ups.status"); err != context.Canceled {
+		t.Fatalf("%#v != %#v", context.Canceled, err)
+	}
+}
+
+func TestCRLF(t *testing.T) {
+	responses := map[string]string{
+		"LIST VAR ups":       strings.ReplaceAll(testStatus, "\n", "\r\n"),
+		"GET VAR ups ups.id": `VAR ups ups.id "my ups"` + "\r",
+		"VER":                "upsd\r",
+		"FSD ups":            "OK FSD-SET\r",
+	}
+	c := newTestClient(t, &Config{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c1, c2 := net.Pipe()
+			go serveTestConn(c2, responses, nil)
+			return c1, nil
+		},
+	})
+	if v, err := c.GetVar("ups", "ups.id"); err != nil || v != "my ups" {
+		t.Fatalf("%#v != %#v (%v)", "my ups", v, err)
+	}
+	if v, err := c.Version(); err != nil || v != "upsd" {
+		t.Fatalf("%#v != %#v (%v)", "upsd", v, err)
+	}
+	if err := c.FSD("ups"); err != nil {
+		t.Fatal(err)
+	}
+	if v := c.Status(); v["ups.status"] != "OL" {
+		t.Fatalf("%#v != %#v", "OL", v["ups.status"])
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	newTestClient(t, &Config{
+		Addr: "upsd:3493",
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if network != "tcp" || addr != "upsd:3493" {
+				t.Errorf("unexpected address %s/%s", network, addr)
+			}
+			c1, c2 := net.Pipe()
+			go serveTestConn(c2, map[string]string{
+				"LIST VAR ups": testStatus,
+			}, nil)
+			return c1, nil
+		},
+	})
+}
+
+func TestPoolSize(t *testing.T) {
+	var (
+		addr = newTestServer(t, map[string]string{
+			"LIST VAR ups":               testStatus,
+			"GET VAR ups battery.charge": `VAR ups battery.charge "100"`,
+		})
+		dialChan = make(chan any, 3)
+		c        = newTestClient(t, &Config{
+			Addr: addr,
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				dialChan <- nil
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+			PoolSize: 3,
+		})
+	)
+	for i := 0; i < 3; i++ {
+		<-dialChan
+	}
+	errChan := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func() {
+			_, err := c.Get("VAR", "ups", "battery.charge")
+			errChan <- err
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-errChan; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	var (
+		dialChan = make(chan any, 4)
+		started  int32
+		returned int32
+		c        = New(&Config{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+
+				// Each connection takes longer than the last to give up, so
+				// that most likely a pool connection is the last to do so
+				n := atomic.AddInt32(&started, 1)
+				dialChan <- nil
+				<-ctx.Done()
+				time.Sleep(time.Duration(n) * 50 * time.Millisecond)
+				atomic.AddInt32(&returned, 1)
+				return nil, ctx.Err()
+			},
+			PoolSize: 4,
+		})
+	)
+	for i := 0; i < 4; i++ {
+		<-dialChan
+	}
+
+	// Close must wait for the pool connections as well as the main one
+	c.Close()
+	if v := atomic.LoadInt32(&returned); v != 4 {
+		t.Fatalf("%#v != %#v", 4, v)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	var (
+		timeoutChan = make(chan time.Duration, 2)
+		c           = New(&Config{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				deadline, ok := ctx.Deadline()
+				if !ok {
+					t.Error("dial context has no deadline")
+				}
+				select {
+				case timeoutChan <- time.Until(deadline):
+				default:
+				}
+				return nil, errors.New("connection refused")
+			},
+			DialTimeout:       time.Minute,
+			ReconnectInterval: time.Millisecond,
+		})
+	)
+	defer c.Close()
+
+	// Both attempts must use the dial timeout even though the client waits
+	// for a much shorter time between them
+	for i := 0; i < 2; i++ {
+		if d := <-timeoutChan; d <= 50*time.Second || d > time.Minute {
+			t.Fatalf("%s is not close to %s", d, time.Minute)
+		}
+	}
+}
+
+// keepAliveTestConn records the keepalive settings applied to it.
+type keepAliveTestConn struct {
+	net.Conn
+	mutex     sync.Mutex
+	keepAlive bool
+	period    time.Duration
+}
+
+func (k *keepAliveTestConn) SetKeepAlive(keepAlive bool) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.keepAlive = keepAlive
+	return nil
+}
+
+func (k *keepAliveTestConn) SetKeepAlivePeriod(d time.Duration) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.period = d
+	return nil
+}
+
+func TestTCPKeepAlive(t *testing.T) {
+	connChan := make(chan *keepAliveTestConn, 1)
+	newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": testStatus,
+		}),
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			k := &keepAliveTestConn{Conn: conn}
+			connChan <- k
+			return k, nil
+		},
+		TCPKeepAlive: 30 * time.Second,
+	})
+	k := <-connChan
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if !k.keepAlive || k.period != 30*time.Second {
+		t.Fatalf("%#v != %#v (%v)", 30*time.Second, k.period, k.keepAlive)
+	}
+
+	// Connections without keepalive support are left unchanged
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	if err := setKeepAlive(c1, 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestErrorFn(t *testing.T) {
+	var (
+		errChan  = make(chan int)
+		doneChan = make(chan any)
+		c        = New(&Config{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return nil, errors.New("connection refused")
+			},
+			ReconnectInterval: time.Millisecond,
+			ErrorFn: func(err error, failures int) {
+				select {
+				case errChan <- failures:
+				case <-doneChan:
+				}
+			},
+		})
+	)
+	defer c.Close()
+	defer close(doneChan)
+	for i := 1; i <= 3; i++ {
+		if v := <-errChan; v != i {
+			t.Fatalf("%#v != %#v", i, v)
+		}
+	}
+}
+
+func TestConnectError(t *testing.T) {
+	var (
+		errRefused = errors.New("connection refused")
+		errChan    = make(chan error, 1)
+		c          = New(&Config{
+			Addr: "upsd:3493",
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return nil, errRefused
+			},
+			ReconnectInterval: time.Minute,
+			ErrorFn: func(err error, failures int) {
+				errChan <- err
+			},
+		})
+	)
+	defer c.Close()
+	err := <-errChan
+	var connectErr *ConnectError
+	if !errors.As(err, &connectErr) {
+		t.Fatalf("%#v is not a ConnectError", err)
+	}
+	if connectErr.Addr != "upsd:3493" {
+		t.Fatalf("%#v != %#v", "upsd:3493", connectErr.Addr)
+	}
+	if !errors.Is(err, errRefused) {
+		t.Fatalf("%#v != %#v", errRefused, err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr:         newTestStatusServer(t, testStatus, testListing("OB")),
+		PollInterval: time.Millisecond,
+	})
+	for _, v := range []EventType{Connected, PowerLost} {
+		if e := <-c.Events(); e.Type != v {
+			t.Fatalf("%#v != %#v", v, e.Type)
+		}
+	}
+	if !c.OnBattery() {
+		t.Fatal("UPS should be on battery")
+	}
+	c.Close()
+	if _, ok := <-c.Events(); ok {
+		t.Fatal("channel should be closed")
+	}
+}
+
+func TestLowBattery(t *testing.T) {
+	lowBatteryChan := make(chan any)
+	newTestClient(t, &Config{
+		Addr:         newTestStatusServer(t, testStatus, testListing("OB LB")),
+		PollInterval: time.Millisecond,
+		LowBatteryFn: func() {
+			close(lowBatteryChan)
+		},
+	})
+	<-lowBatteryChan
+}
+
+func TestFirstStatus(t *testing.T) {
+	var (
+		statusChan = make(chan any, 2)
+		eventChan  = make(chan string, 4)
+	)
+	newTestClient(t, &Config{
+		Addr: newTestStatusServer(
+			t,
+			testListing("OB LB RB"),
+			testListing("OB LB RB DISCHRG"),
+		),
+		PollInterval: time.Millisecond,
+		StatusChangedFn: func(old, new string) {
+			statusChan <- nil
+		},
+		PowerLostFn: func() {
+			eventChan <- "PowerLostFn"
+		},
+		LowBatteryFn: func() {
+			eventChan <- "LowBatteryFn"
+		},
+		ReplaceBatteryFn: func() {
+			eventChan <- "ReplaceBatteryFn"
+		},
+	})
+
+	// The first status only establishes whether the UPS is on battery, so
+	// once the second has been received, only the battery flags should have
+	// been reported
+	<-statusChan
+	<-statusChan
+	events := []string{}
+	for n := len(eventChan); n > 0; n-- {
+		events = append(events, <-eventChan)
+	}
+	if v := []string{"LowBatteryFn", "ReplaceBatteryFn"}; !reflect.DeepEqual(v, events) {
+		t.Fatalf("%#v != %#v", v, events)
+	}
+}
+
+func TestChargeThreshold(t *testing.T) {
+	var (
+		c = &Client{
+			cfg: &Config{
+				ChargeThreshold: 20,
+			},
+		}
+		output = []float64{}
+	)
+	c.cfg.ChargeThresholdFn = func(percent float64) {
+		output = append(output, percent)
+	}
+	for _, v := range []float64{50, 19, 15, 30, 10} {
+		c.checkCharge(v)
+	}
+	if v := []float64{19, 10}; !reflect.DeepEqual(v, output) {
+		t.Fatalf("%#v != %#v", v, output)
+	}
+}
+
+func TestSetName(t *testing.T) {
+	var (
+		statusChan = make(chan [2]string, 1)
+		c          = newTestClient(t, &Config{
+			Addr: newTestServer(t, map[string]string{
+				"LIST VAR ups": testStatus,
+				"LIST VAR ups2": `BEGIN LIST VAR ups2
+VAR ups2 ups.status "OB"
+END LIST VAR ups2`,
+			}),
+			PollInterval: 10 * time.Millisecond,
+			StatusChangedFn: func(old, new string) {
+				statusChan <- [2]string{old, new}
+			},
+		})
+	)
+	if v := <-statusChan; v != [2]string{"", "OL"} {
+		t.Fatalf("%#v != %#v", [2]string{"", "OL"}, v)
+	}
+	c.SetName("ups2")
+	if v := <-statusChan; v != [2]string{"", "OB"} {
+		t.Fatalf("%#v != %#v", [2]string{"", "OB"}, v)
+	}
+}
+
+func TestSetNameRace(t *testing.T) {
+	var (
+		statusChan = make(chan any, 16)
+		c          = newTestClient(t, &Config{
+			Addr: newTestServer(t, map[string]string{
+				"LIST VAR ups": testStatus,
+				"LIST VAR ups2": `BEGIN LIST VAR ups2
+VAR ups2 ups.status "OB"
+END LIST VAR ups2`,
+			}),
+			PollInterval: time.Millisecond,
+			StatusChangedFn: func(old, new string) {
+				statusChan <- nil
+			},
+		})
+	)
+	<-statusChan
+	for _, name := range []string{"ups2", "ups", "ups2"} {
+		c.SetName(name)
+		for {
+			c.OnBattery()
+			c.Metrics()
+			select {
+			case <-statusChan:
+			default:
+				continue
+			}
+			break
+		}
+	}
+}
+
+func TestCalibration(t *testing.T) {
+	listing := func(status string, charge int) string {
+		return fmt.Sprintf(
+			"BEGIN LIST VAR ups\nVAR ups ups.status %q\nVAR ups battery.charge \"%d\"\nEND LIST VAR ups",
+			status,
+			charge,
+		)
+	}
+	for _, useChargeTrend := range []bool{false, true} {
+		var (
+			calibrationChan = make(chan bool, 1)
+			statusChan      = make(chan string, 4)
+			powerLostChan   = make(chan any, 1)
+			c               = newTestClient(t, &Config{
+				Addr: newTestStatusServer(
+					t,
+					listing("OL", 100),
+					listing("OB CAL", 95),
+					listing("OB CAL", 90),
+					listing("OB CAL DISCHRG", 85),
+				),
+				PollInterval: time.Millisecond,
+				CalibrationFn: func(active bool) {
+					calibrationChan <- active
+				},
+				IgnoreCalibration: true,
+				UseChargeTrend:    useChargeTrend,
+				StatusChangedFn: func(old, new string) {
+					statusChan <- new
+				},
+				PowerLostFn: func() {
+					powerLostChan <- nil
+				},
+			})
+		)
+		if v := <-calibrationChan; !v {
+			t.Fatalf("%v: %#v != %#v", useChargeTrend, true, v)
+		}
+
+		// Once the final status is received, the falling charge has been
+		// seen and must not have been treated as running on battery
+		for v := range statusChan {
+			if v == "OB CAL DISCHRG" {
+				break
+			}
+		}
+		if len(powerLostChan) != 0 {
+			t.Fatalf("%v: PowerLostFn invoked during calibration", useChargeTrend)
+		}
+		if c.OnBattery() {
+			t.Fatalf("%v: UPS should not be on battery", useChargeTrend)
+		}
+	}
+}
+
+func TestStatusChanged(t *testing.T) {
+	statusChan := make(chan [2]string, 1)
+	newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": `BEGIN LIST VAR ups
+VAR ups ups.status "OL CHRG"
+END LIST VAR ups`,
+		}),
+		StatusChangedFn: func(old, new string) {
+			statusChan <- [2]string{old, new}
+		},
+	})
+	if v := <-statusChan; v != [2]string{"", "OL CHRG"} {
+		t.Fatalf("%#v != %#v", [2]string{"", "OL CHRG"}, v)
+	}
+}
+
+func TestVarChanged(t *testing.T) {
+	varChan := make(chan [2]string, 2)
+	newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": `BEGIN LIST VAR ups
+VAR ups ups.status "OL"
+VAR ups ups.load "23"
+VAR ups input.voltage "120.0"
+END LIST VAR ups`,
+		}),
+		WatchVars: []string{"ups.load", "battery.runtime"},
+		VarChangedFn: func(name, value string) {
+			varChan <- [2]string{name, value}
+		},
+	})
+	if v := <-varChan; v != [2]string{"ups.load", "23"} {
+		t.Fatalf("%#v != %#v", [2]string{"ups.load", "23"}, v)
+	}
+}
+
+func TestRuntimeThreshold(t *testing.T) {
+	var (
+		c = &Client{
+			cfg: &Config{
+				RuntimeThreshold: 300,
+			},
+		}
+		output = []int{}
+	)
+	c.cfg.RuntimeThresholdFn = func(seconds int) {
+		output = append(output, seconds)
+	}
+	for _, v := range []int{1200, 299, 240, 600, 120} {
+		c.checkRuntime(v)
+	}
+	if v := []int{299, 120}; !reflect.DeepEqual(v, output) {
+		t.Fatalf("%#v != %#v", v, output)
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	disconnectedChan := make(chan error, 1)
+	c := newTestClient(t, &Config{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c1, c2 := net.Pipe()
+			go func() {
+				defer c2.Close()
+				s := bufio.NewScanner(c2)
+				for s.Scan() {
+
+					// Only reply to the status poll
+					if s.Text() == "LIST VAR ups" {
+						if _, err := c2.Write([]byte(testStatus + "\n")); err != nil {
+							return
+						}
+					}
+				}
+			}()
+			return c1, nil
+		},
+		CommandTimeout: 50 * time.Millisecond,
+		DisconnectedErrFn: func(err error) {
+			disconnectedChan <- err
+		},
+	})
+	if _, err := c.Version(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("%#v != %#v", os.ErrDeadlineExceeded, err)
+	}
+	if err := <-disconnectedChan; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("%#v != %#v", os.ErrDeadlineExceeded, err)
+	}
+}
+
+func TestServerClosed(t *testing.T) {
+	c := newTestClient(t, &Config{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c1, c2 := net.Pipe()
+			go func() {
+				defer c2.Close()
+
+				// Reply to the status poll and then close the connection
+				// when the next command is received
+				s := bufio.NewScanner(c2)
+				for s.Scan() {
+					if s.Text() != "LIST VAR ups" {
+						return
+					}
+					if _, err := c2.Write([]byte(testStatus + "\n")); err != nil {
+						return
+					}
+				}
+			}()
+			return c1, nil
+		},
+	})
+	if _, err := c.Version(); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("%#v != %#v", ErrServerClosed, err)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	c := newTestClient(t, &Config{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c1, c2 := net.Pipe()
+			go func() {
+
+				// Reply to the status poll and then stop reading
+				s := bufio.NewScanner(c2)
+				if s.Scan() {
+					c2.Write([]byte(testStatus + "\n"))
+				}
+			}()
+			t.Cleanup(func() { c2.Close() })
+			return c1, nil
+		},
+		WriteTimeout: 50 * time.Millisecond,
+	})
+	if _, err := c.Version(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("%#v != %#v", os.ErrDeadlineExceeded, err)
+	}
+}
+
+func TestCommandRetries(t *testing.T) {
+	var (
+		mutex     sync.Mutex
+		dialCount int
+		responses = map[string]string{
+			"LIST VAR ups":               testStatus,
+			"GET VAR ups battery.charge": `VAR ups battery.charge "100"`,
+		}
+	)
+	c := newTestClient(t, &Config{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			dialCount++
+			c1, c2 := net.Pipe()
+			if dialCount == 1 {
+
+				// Drop the first connection as soon as a command is sent
+				go func() {
+					defer c2.Close()
+					s := bufio.NewScanner(c2)
+					for s.Scan() {
+						if s.Text() != "LIST VAR ups" {
+							return
+						}
+						if _, err := c2.Write([]byte(testStatus + "\n")); err != nil {
+							return
+						}
+					}
+				}()
+			} else {
+				go serveTestConn(c2, responses, nil)
+			}
+			return c1, nil
+		},
+		ReconnectInterval: 10 * time.Millisecond,
+		CommandRetries:    1,
+	})
+	v, err := c.Get("VAR", "ups", "battery.charge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "100" {
+		t.Fatalf("%#v != %#v", "100", v)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestStatusServer(t, testStatus, `BEGIN LIST VAR ups
+VAR ups ups.status "OB DISCHRG"
+VAR ups battery.charge "87"
+VAR ups battery.runtime "1520"
+END LIST VAR ups`),
+		PollInterval: time.Millisecond,
+	})
+	for e := range c.Events() {
+		if e.Type == PowerLost {
+			break
+		}
+	}
+	m := c.Metrics()
+	m.LastPollTime = time.Time{}
+	v := Metrics{
+		OnBattery:      true,
+		BatteryCharge:  87,
+		RuntimeSeconds: 1520,
+	}
+	if m != v {
+		t.Fatalf("%#v != %#v", v, m)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": testStatus,
+			"LIST VAR ups2": `BEGIN LIST VAR ups2
+VAR ups2 ups.status "OB DISCHRG"
+VAR ups2 battery.charge "87.5"
+VAR ups2 battery.runtime "1200"
+VAR ups2 ups.load "23"
+END LIST VAR ups2`,
+		}),
+	})
+	s, err := c.Snapshot("ups2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := Snapshot{
+		Status:         "OB DISCHRG",
+		BatteryCharge:  87.5,
+		BatteryRuntime: 1200,
+		Load:           23,
+		Missing:        []string{"input.voltage"},
+	}
+	if !reflect.DeepEqual(v, s) {
+		t.Fatalf("%#v != %#v", v, s)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	var (
+		mutex   sync.Mutex
+		count   int
+		listing = []string{
+			`BEGIN LIST VAR ups2
+VAR ups2 ups.status "OL"
+VAR ups2 battery.charge "100"
+END LIST VAR ups2`,
+			`BEGIN LIST VAR ups2
+VAR ups2 ups.status "OB"
+END LIST VAR ups2`,
+		}
+	)
+	c := newTestClient(t, &Config{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c1, c2 := net.Pipe()
+			go func() {
+				defer c2.Close()
+				s := bufio.NewScanner(c2)
+				for s.Scan() {
+					r := testStatus
+					if s.Text() == "LIST VAR ups2" {
+						mutex.Lock()
+						r = listing[count]
+						if count < len(listing)-1 {
+							count++
+						}
+						mutex.Unlock()
+					}
+					if _, err := c2.Write([]byte(r + "\n")); err != nil {
+						return
+					}
+				}
+			}()
+			return c1, nil
+		},
+	})
+	ch, cancel := c.Watch("ups2", 10*time.Millisecond)
+	changes := map[string]VarChange{}
+	for i := 0; i < 2; i++ {
+		v := <-ch
+		changes[v.Name] = v
+	}
+	v := map[string]VarChange{
+		"ups.status":     {Name: "ups.status", Old: "OL", New: "OB"},
+		"battery.charge": {Name: "battery.charge", Old: "100"},
+	}
+	if !reflect.DeepEqual(v, changes) {
+		t.Fatalf("%#v != %#v", v, changes)
+	}
+	cancel()
+	for range ch {
+	}
+}
+
+func TestListClients(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": testStatus,
+			"LIST CLIENT ups": `BEGIN LIST CLIENT ups
+CLIENT ups 127.0.0.1
+CLIENT ups ::1
+END LIST CLIENT ups`,
+		}),
+	})
+	clients, err := c.ListClients("ups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := []string{"127.0.0.1", "::1"}; !reflect.DeepEqual(v, clients) {
+		t.Fatalf("%#v != %#v", v, clients)
+	}
+	if _, err := c.ListClients("ups2"); !errors.Is(err, ErrUnknownCommand) {
+		t.Fatalf("%#v != %#v", ErrUnknownCommand, err)
+	}
+}
+
+func TestServerInfo(t *testing.T) {
+	infoChan := make(chan [2]string, 1)
+	newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"VER":          "upsd 2.8.0",
+			"PROTVER":      "1.3",
+			"LIST VAR ups": testStatus,
+		}),
+		OnConnectProbe: true,
+		ServerInfoFn: func(version, protocol string) {
+			infoChan <- [2]string{version, protocol}
+		},
+	})
+	if v := <-infoChan; v != [2]string{"upsd 2.8.0", "1.3"} {
+		t.Fatalf("%#v != %#v", [2]string{"upsd 2.8.0", "1.3"}, v)
+	}
+}
+
+func TestCloseContext(t *testing.T) {
+	disconnectedChan := make(chan any)
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": testStatus,
+			"LOGOUT":       "OK Goodbye",
+		}),
+		DisconnectedFn: func() {
+			close(disconnectedChan)
+		},
+	})
+	if err := c.CloseContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-disconnectedChan:
+		t.Fatal("DisconnectedFn should not be invoked")
+	default:
+	}
+	if err := c.CloseContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRaw(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups":           testStatus,
+			"GET UPSDESC ups":        `UPSDESC ups "Test UPS"`,
+			"GET TRACKING 1234-abcd": "ERR UNKNOWN-COMMAND",
+		}),
+	})
+	tokens, err := c.Raw("GET UPSDESC ups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := []string{"UPSDESC", "ups", "Test UPS"}; !reflect.DeepEqual(v, tokens) {
+		t.Fatalf("%#v != %#v", v, tokens)
+	}
+	if _, err := c.Raw("GET TRACKING 1234-abcd"); !errors.Is(err, ErrUnknownCommand) {
+		t.Fatalf("%#v != %#v", ErrUnknownCommand, err)
+	}
+}
+
+func TestReplaceBattery(t *testing.T) {
+	var (
+		statusChan         = make(chan string, 16)
+		replaceBatteryChan = make(chan any, 16)
+	)
+	newTestClient(t, &Config{
+		Addr: newTestStatusServer(
+			t,
+			testStatus,
+			testListing("OL RB"),
+			testListing("OL RB"),
+			testListing("OL RB"),
+			testListing("OL RB CHRG"),
+		),
+		PollInterval: time.Millisecond,
+		StatusChangedFn: func(old, new string) {
+			statusChan <- new
+		},
+		ReplaceBatteryFn: func() {
+			replaceBatteryChan <- nil
+		},
+	})
+
+	// Once the final status is received, every poll reporting RB has been
+	// handled and the callback should only have been invoked for the first
+	for v := range statusChan {
+		if v == "OL RB CHRG" {
+			break
+		}
+	}
+	if n := len(replaceBatteryChan); n != 1 {
+		t.Fatalf("%#v != %#v", 1, n)
+	}
+}
@@ -0,0 +1,41 @@
+package nutclient
+
+import (
+	"time"
+)
+
+// clock provides the current time and timers so that tests can control the
+// passage of time.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) ticker
+}
+
+// ticker is the subset of time.Ticker used by the client.
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTicker(d time.Duration) ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (r realTicker) C() <-chan time.Time {
+	return r.Ticker.C
+}
@@ -0,0 +1,471 @@
+package nutclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only advances when told to. The duration passed
+// to each call to After and NewTicker is sent on afterChan and tickerChan
+// respectively.
+type fakeClock struct {
+	mutex      sync.Mutex
+	now        time.Time
+	timers     []*fakeTimer
+	afterChan  chan time.Duration
+	tickerChan chan time.Duration
+}
+
+type fakeTimer struct {
+	clock  *fakeClock
+	when   time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+func (f *fakeTimer) C() <-chan time.Time {
+	return f.c
+}
+
+func (f *fakeTimer) Stop() {
+	f.clock.mutex.Lock()
+	defer f.clock.mutex.Unlock()
+	f.period = 0
+	f.when = time.Time{}
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:        time.Unix(0, 0),
+		afterChan:  make(chan time.Duration, 16),
+		tickerChan: make(chan time.Duration, 16),
+	}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) addTimer(d, period time.Duration) *fakeTimer {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	t := &fakeTimer{
+		clock:  f,
+		when:   f.now.Add(d),
+		period: period,
+		c:      make(chan time.Time, 1),
+	}
+	f.timers = append(f.timers, t)
+	return t
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	t := f.addTimer(d, 0)
+	f.afterChan <- d
+	return t.c
+}
+
+func (f *fakeClock) NewTicker(d time.Duration) ticker {
+	t := f.addTimer(d, d)
+	f.tickerChan <- d
+	return t
+}
+
+// Advance moves the clock forward, firing any timers that are due.
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = f.now.Add(d)
+	timers := []*fakeTimer{}
+	for _, t := range f.timers {
+		if t.when.IsZero() {
+			continue
+		}
+		if t.when.After(f.now) {
+			timers = append(timers, t)
+			continue
+		}
+		select {
+		case t.c <- f.now:
+		default:
+		}
+		if t.period != 0 {
+			t.when = f.now.Add(t.period)
+			timers = append(timers, t)
+		}
+	}
+	f.timers = timers
+}
+
+func TestClockReconnect(t *testing.T) {
+	var (
+		f = newFakeClock()
+		c = newClient(&Config{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return nil, errors.New("connection refused")
+			},
+			ReconnectInterval:    5 * time.Second,
+			MaxReconnectInterval: 20 * time.Second,
+		}, f)
+	)
+	c.backoff.jitterFn = func(time.Duration) time.Duration {
+		return 0
+	}
+	go c.run()
+	defer c.Close()
+	output := []time.Duration{}
+	for i := 0; i < 4; i++ {
+		d := <-f.afterChan
+		output = append(output, d)
+		f.Advance(d)
+	}
+	v := []time.Duration{
+		5 * time.Second,
+		10 * time.Second,
+		20 * time.Second,
+		20 * time.Second,
+	}
+	if !reflect.DeepEqual(v, output) {
+		t.Fatalf("%#v != %#v", v, output)
+	}
+}
+
+func TestClockPoll(t *testing.T) {
+	var (
+		f        = newFakeClock()
+		pollChan = make(chan any, 16)
+		c        = newClient(&Config{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				c1, c2 := net.Pipe()
+				go func() {
+					defer c2.Close()
+					s := bufio.NewScanner(c2)
+					for s.Scan() {
+						pollChan <- nil
+						if _, err := c2.Write([]byte(testStatus + "\n")); err != nil {
+							return
+						}
+					}
+				}()
+				return c1, nil
+			},
+			PollInterval: 10 * time.Second,
+		}, f)
+	)
+	go c.run()
+	defer c.Close()
+
+	// The status is polled immediately after connecting and then once for
+	// each interval that elapses
+	<-pollChan
+	if d := <-f.tickerChan; d != 10*time.Second {
+		t.Fatalf("%#v != %#v", 10*time.Second, d)
+	}
+	for i := 0; i < 3; i++ {
+		f.Advance(10 * time.Second)
+		<-pollChan
+	}
+}
+
+func TestClockStartupJitter(t *testing.T) {
+	var (
+		f        = newFakeClock()
+		dialChan = make(chan any, 1)
+		c        = newClient(&Config{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				dialChan <- nil
+				return nil, errors.New("connection refused")
+			},
+			StartupJitter: 10 * time.Second,
+		}, f)
+	)
+	go c.run()
+	defer c.Close()
+	d := <-f.afterChan
+	if d < 0 || d >= 10*time.Second {
+		t.Fatalf("%s is not within [0, 10s)", d)
+	}
+	select {
+	case <-dialChan:
+		t.Fatal("connection attempted before delay elapsed")
+	default:
+	}
+	f.Advance(d)
+	<-dialChan
+}
+
+func TestClockReconnected(t *testing.T) {
+	var (
+		f               = newFakeClock()
+		reconnectedChan = make(chan time.Duration, 1)
+		mutex           sync.Mutex
+		dialCount       int
+		c               = newClient(&Config{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				dialCount++
+				c1, c2 := net.Pipe()
+				if dialCount == 1 {
+
+					// Close the first connection after the status poll
+					go func() {
+						defer c2.Close()
+						s := bufio.NewScanner(c2)
+						if s.Scan() {
+							c2.Write([]byte(testStatus + "\n"))
+						}
+					}()
+				} else {
+					go serveTestConn(c2, map[string]string{
+						"LIST VAR ups": testStatus,
+					}, nil)
+				}
+				return c1, nil
+			},
+			ReconnectInterval: 5 * time.Second,
+			ReconnectedFn: func(downtime time.Duration) {
+				reconnectedChan <- downtime
+			},
+		}, f)
+	)
+	c.backoff.jitterFn = func(time.Duration) time.Duration {
+		return 0
+	}
+	go c.run()
+	defer c.Close()
+
+	// The connection is found to be closed when the status is next polled
+	f.Advance(<-f.tickerChan)
+	f.Advance(<-f.afterChan)
+	if d := <-reconnectedChan; d != 5*time.Second {
+		t.Fatalf("%#v != %#v", 5*time.Second, d)
+	}
+}
+
+func TestClockMaxReconnectAttempts(t *testing.T) {
+	var (
+		f          = newFakeClock()
+		errRefused = errors.New("connection refused")
+		gaveUpChan = make(chan error, 1)
+		c          = newClient(&Config{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return nil, errRefused
+			},
+			MaxReconnectAttempts: 3,
+			GaveUpFn: func(lastErr error) {
+				gaveUpChan <- lastErr
+			},
+		}, f)
+	)
+	go c.run()
+	defer c.Close()
+	for i := 0; i < 2; i++ {
+		f.Advance(<-f.afterChan)
+	}
+	if err := <-gaveUpChan; !errors.Is(err, errRefused) {
+		t.Fatalf("%#v != %#v", errRefused, err)
+	}
+	if err := c.Ping(); !errors.Is(err, errNotConnected) {
+		t.Fatalf("%#v != %#v", errNotConnected, err)
+	}
+}
+
+func TestClockHistory(t *testing.T) {
+	var (
+		f          = newFakeClock()
+		statusChan = make(chan any, 16)
+		c          = newClient(&Config{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				c1, c2 := net.Pipe()
+				go func() {
+					defer c2.Close()
+					s := bufio.NewScanner(c2)
+					for i := 0; s.Scan(); i++ {
+						status := []string{"OL", "OB", "OB LB"}[i%3]
+						if _, err := fmt.Fprintf(
+							c2,
+							"BEGIN LIST VAR ups\nVAR ups ups.status %q\nEND LIST VAR ups\n",
+							status,
+						); err != nil {
+							return
+						}
+					}
+				}()
+				return c1, nil
+			},
+			PollInterval: 10 * time.Second,
+			HistorySize:  2,
+			StatusChangedFn: func(old, new string) {
+				statusChan <- nil
+			},
+		}, f)
+	)
+	go c.run()
+	defer c.Close()
+	<-statusChan
+	<-f.tickerChan
+	for i := 0; i < 2; i++ {
+		f.Advance(10 * time.Second)
+		<-statusChan
+	}
+	v := []StatusEvent{
+		{Old: "OL", New: "OB", Time: time.Unix(10, 0)},
+		{Old: "OB", New: "OB LB", Time: time.Unix(20, 0)},
+	}
+	if h := c.History(); !reflect.DeepEqual(v, h) {
+		t.Fatalf("%#v != %#v", v, h)
+	}
+}
+
+func TestClockStats(t *testing.T) {
+	var (
+		f = newFakeClock()
+		c = newClient(&Config{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				c1, c2 := net.Pipe()
+				go serveTestConn(c2, map[string]string{
+					"LIST VAR ups": testStatus,
+					"VER":          "upsd",
+				}, nil)
+				return c1, nil
+			},
+			PollInterval: time.Minute,
+		}, f)
+	)
+	go c.run()
+	defer c.Close()
+	<-f.tickerChan
+	f.Advance(10 * time.Second)
+	if _, err := c.Version(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.ProtocolVersion(); err == nil {
+		t.Fatal("error expected")
+	}
+	v := Stats{
+		ConnectCount:  1,
+		CommandCount:  3,
+		CommandErrors: 2,
+		CurrentUptime: 10 * time.Second,
+	}
+	if s := c.Stats(); !reflect.DeepEqual(v, s) {
+		t.Fatalf("%#v != %#v", v, s)
+	}
+}
+
+func TestClockDebounce(t *testing.T) {
+	var (
+		f          = newFakeClock()
+		statusChan = make(chan any, 16)
+		powerChan  = make(chan time.Time, 16)
+		c          = newClient(&Config{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				c1, c2 := net.Pipe()
+				go func() {
+					defer c2.Close()
+					s := bufio.NewScanner(c2)
+					for i := 0; s.Scan(); i++ {
+						status := "OB"
+						if i < 3 {
+							status = []string{"OL", "OB", "OL"}[i]
+						}
+						if _, err := fmt.Fprintf(
+							c2,
+							"BEGIN LIST VAR ups\nVAR ups ups.status %q\nEND LIST VAR ups\n",
+							status,
+						); err != nil {
+							return
+						}
+					}
+				}()
+				return c1, nil
+			},
+			PollInterval: 10 * time.Second,
+			Debounce:     20 * time.Second,
+			StatusChangedFn: func(old, new string) {
+				statusChan <- nil
+			},
+			PowerLostFn: func() {
+				powerChan <- f.Now()
+			},
+		}, f)
+	)
+	go c.run()
+	defer c.Close()
+	<-statusChan
+	<-f.tickerChan
+
+	// The brief loss of power at 10s is reversed at 20s and never reported
+	f.Advance(10 * time.Second)
+	<-statusChan
+	<-f.afterChan
+	f.Advance(10 * time.Second)
+	<-statusChan
+
+	// The loss of power at 30s is reported once it persists until 50s
+	f.Advance(10 * time.Second)
+	<-statusChan
+	<-f.afterChan
+	for i := 0; i < 2; i++ {
+		f.Advance(10 * time.Second)
+	}
+	if v := <-powerChan; !v.Equal(time.Unix(50, 0)) {
+		t.Fatalf("%#v != %#v", time.Unix(50, 0), v)
+	}
+}
+
+func TestClockAuthenticationFailure(t *testing.T) {
+	var (
+		f          = newFakeClock()
+		gaveUpChan = make(chan error, 1)
+		c          = newClient(&Config{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				c1, c2 := net.Pipe()
+				go serveTestConn(c2, map[string]string{
+					"USERNAME admin": "OK",
+					"PASSWORD wrong": "ERR ACCESS-DENIED",
+					"LIST VAR ups":   testStatus,
+				}, nil)
+				return c1, nil
+			},
+			Username:             "admin",
+			Password:             "wrong",
+			ReconnectInterval:    5 * time.Second,
+			MaxReconnectInterval: 20 * time.Second,
+			MaxReconnectAttempts: 3,
+			GaveUpFn: func(lastErr error) {
+				gaveUpChan <- lastErr
+			},
+		}, f)
+	)
+	c.backoff.jitterFn = func(time.Duration) time.Duration {
+		return 0
+	}
+	go c.run()
+	defer c.Close()
+
+	// Connecting succeeds every time but the rejected credentials must still
+	// count as failures, increasing the interval until the client gives up
+	output := []time.Duration{}
+	for i := 0; i < 2; i++ {
+		d := <-f.afterChan
+		output = append(output, d)
+		f.Advance(d)
+	}
+	if v := []time.Duration{5 * time.Second, 10 * time.Second}; !reflect.DeepEqual(v, output) {
+		t.Fatalf("%#v != %#v", v, output)
+	}
+	if err := <-gaveUpChan; !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("%#v != %#v", ErrAccessDenied, err)
+	}
+}
@@ -0,0 +1,401 @@
+package nutclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// List runs the LIST command with the provided arguments and returns each row
+// of the response. The tokens that begin every row (such as "VAR ups" for
+// "LIST VAR ups") are removed.
+func (c *Client) List(args ...string) ([][]string, error) {
+	v, err := c.send(typeList, args...)
+	if err != nil {
+		return nil, err
+	}
+	return v.([][]string), nil
+}
+
+func (c *Client) get(ctx context.Context, args ...string) ([]string, error) {
+	v, err := c.sendContext(ctx, typeGet, args...)
+	if err != nil {
+		return nil, err
+	}
+	return v.([]string), nil
+}
+
+// Get runs the GET command with the provided arguments and returns the value
+// from the response.
+func (c *Client) Get(args ...string) (string, error) {
+	return c.GetContext(context.Background(), args...)
+}
+
+// GetContext is identical to Get but returns ctx.Err() if ctx is done before
+// the response is received. If the command has not yet been sent to the
+// server at that point, it is skipped entirely.
+func (c *Client) GetContext(ctx context.Context, args ...string) (string, error) {
+	v, err := c.get(ctx, args...)
+	if err != nil {
+		return "", err
+	}
+	if len(v) == 0 {
+		return "", errValueMissing
+	}
+	return v[0], nil
+}
+
+// TryGet is identical to Get but fails immediately if the client is not
+// connected to the server instead of waiting for the connection to accept
+// the command, which can take as long as ReconnectInterval. If the client is
+// connected, TryGet still waits for any commands that are already running.
+func (c *Client) TryGet(args ...string) (string, error) {
+	if !c.IsConnected() {
+		return "", errNotConnected
+	}
+	return c.Get(args...)
+}
+
+// GetFloat runs the GET command with the provided arguments and parses the
+// value from the response as a floating-point number.
+func (c *Client) GetFloat(args ...string) (float64, error) {
+	v, err := c.Get(args...)
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse %q: %w", v, err)
+	}
+	return f, nil
+}
+
+// GetInt runs the GET command with the provided arguments and parses the
+// value from the response as an integer.
+func (c *Client) GetInt(args ...string) (int64, error) {
+	v, err := c.Get(args...)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse %q: %w", v, err)
+	}
+	return i, nil
+}
+
+// InstCmd sends an instant command (such as "test.battery.start") to the
+// specified UPS. An error is returned if the server does not reply with OK.
+func (c *Client) InstCmd(ups, command string) error {
+	_, err := c.send(typeCmd, "INSTCMD", ups, command)
+	return err
+}
+
+// Set changes the value of a writable variable on the specified UPS.
+// ErrReadOnly is returned if the variable cannot be changed and
+// ErrAccessDenied if the client is not permitted to change it.
+func (c *Client) Set(ups, name, value string) error {
+	_, err := c.send(typeSet, "SET", "VAR", ups, name, value)
+	return err
+}
+
+// UPSInfo provides the name and description of a UPS.
+type UPSInfo struct {
+	Name        string
+	Description string
+}
+
+// ListUPS returns the name and description of each UPS on the server. The
+// description is empty for any UPS that does not have one.
+func (c *Client) ListUPS() ([]UPSInfo, error) {
+	rows, err := c.List("UPS")
+	if err != nil {
+		return nil, err
+	}
+	upsList := []UPSInfo{}
+	for _, row := range rows {
+		if len(row) == 0 {
+			return nil, errRowExpected
+		}
+
+		// The description is absent if none was configured for the UPS
+		info := UPSInfo{Name: row[0]}
+		if len(row) > 1 {
+			info.Description = row[1]
+		}
+		upsList = append(upsList, info)
+	}
+	return upsList, nil
+}
+
+// ListAll returns the variables of every UPS on the server, keyed by the name
+// of the UPS. If the variables for some of the UPS cannot be listed, the
+// others are still returned along with a *ListAllError.
+func (c *Client) ListAll() (map[string]map[string]string, error) {
+	upsList, err := c.ListUPS()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		all     = map[string]map[string]string{}
+		listErr = &ListAllError{Errors: map[string]error{}}
+	)
+	for _, u := range upsList {
+		variables, err := c.ListVars(u.Name)
+		if err != nil {
+			listErr.Errors[u.Name] = err
+			continue
+		}
+		all[u.Name] = variables
+	}
+	if len(listErr.Errors) != 0 {
+		return all, listErr
+	}
+	return all, nil
+}
+
+// ListVars returns the name and value of each variable for the specified UPS.
+func (c *Client) ListVars(ups string) (map[string]string, error) {
+	rows, err := c.List("VAR", ups)
+	if err != nil {
+		return nil, err
+	}
+	return parseVariables(rows)
+}
+
+// VarRow provides the name and value of a variable.
+type VarRow struct {
+	Name  string
+	Value string
+}
+
+// ListVarRows is identical to ListVars but returns the variables in the
+// order they were listed by the server.
+func (c *Client) ListVarRows(ups string) ([]VarRow, error) {
+	rows, err := c.List("VAR", ups)
+	if err != nil {
+		return nil, err
+	}
+	varRows := []VarRow{}
+	for _, row := range rows {
+		switch len(row) {
+		case 0:
+			return nil, errVarNameMissing
+		case 1:
+			return nil, errVarValueMissing
+		}
+		varRows = append(varRows, VarRow{
+			Name:  row[0],
+			Value: row[1],
+		})
+	}
+	return varRows, nil
+}
+
+// ListCommands returns the instant commands supported by the specified UPS.
+func (c *Client) ListCommands(ups string) ([]string, error) {
+	rows, err := c.List("CMD", ups)
+	if err != nil {
+		return nil, err
+	}
+	return parseValues(rows)
+}
+
+// ListRW returns the name and value of each writable variable for the
+// specified UPS. An empty map is returned if none of the variables are
+// writable.
+func (c *Client) ListRW(ups string) (map[string]string, error) {
+	rows, err := c.List("RW", ups)
+	if err != nil {
+		return nil, err
+	}
+	return parseVariables(rows)
+}
+
+// ListEnum returns the values that may be assigned to the specified
+// enumerated variable.
+func (c *Client) ListEnum(ups, name string) ([]string, error) {
+	rows, err := c.List("ENUM", ups, name)
+	if err != nil {
+		return nil, err
+	}
+	return parseValues(rows)
+}
+
+// Range provides the minimum and maximum values of a numeric variable.
+type Range struct {
+	Min string
+	Max string
+}
+
+// ListRange returns the ranges of values that may be assigned to the
+// specified numeric variable.
+func (c *Client) ListRange(ups, name string) ([]Range, error) {
+	rows, err := c.List("RANGE", ups, name)
+	if err != nil {
+		return nil, err
+	}
+	ranges := []Range{}
+	for _, row := range rows {
+		if len(row) < 2 {
+			return nil, errRowExpected
+		}
+		ranges = append(ranges, Range{
+			Min: row[0],
+			Max: row[1],
+		})
+	}
+	return ranges, nil
+}
+
+// GetVar returns the value of the specified variable. ErrVarNotSupported is
+// returned if the UPS does not provide it.
+func (c *Client) GetVar(ups, name string) (string, error) {
+	return c.Get("VAR", ups, name)
+}
+
+// GetDesc returns the description of the specified variable.
+func (c *Client) GetDesc(ups, name string) (string, error) {
+	return c.Get("DESC", ups, name)
+}
+
+// GetType returns the type flags of the specified variable, such as "RW",
+// "ENUM", or "STRING:64".
+func (c *Client) GetType(ups, name string) ([]string, error) {
+	return c.get(context.Background(), "TYPE", ups, name)
+}
+
+// GetCmdDesc returns the description of the specified instant command.
+// ErrCmdNotSupported is returned if the UPS does not support the command.
+func (c *Client) GetCmdDesc(ups, command string) (string, error) {
+	return c.Get("CMDDESC", ups, command)
+}
+
+// GetUPSDesc returns the description of the specified UPS.
+func (c *Client) GetUPSDesc(ups string) (string, error) {
+	return c.Get("UPSDESC", ups)
+}
+
+// GetNumLogins returns the number of clients logged in to the specified UPS.
+func (c *Client) GetNumLogins(ups string) (int, error) {
+	v, err := c.Get("NUMLOGINS", ups)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(v)
+}
+
+// Version returns the version banner of the server.
+func (c *Client) Version() (string, error) {
+	v, err := c.send(typeRaw, "VER")
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
+// Ping sends VER to the server and waits for the reply, which confirms that
+// the connection is working. Unlike IsConnected, this requires a round trip.
+// A server that rejects VER with an error is still considered reachable.
+func (c *Client) Ping() error {
+	_, err := c.send(typeRaw, "VER")
+	return ignoreProtocolError(err)
+}
+
+// SupportedCommands returns the commands that the server advertises in its
+// response to HELP, such as "VER" and "LIST". This can be used to determine
+// whether a command is supported before it is used.
+func (c *Client) SupportedCommands() ([]string, error) {
+	v, err := c.send(typeLine, "HELP")
+	if err != nil {
+		return nil, err
+	}
+	commands, ok := trimPrefix(v.([]string), "commands:")
+	if !ok {
+		return nil, errPrefixMismatch
+	}
+	return commands, nil
+}
+
+// ProtocolVersion returns the version of the network protocol used by the
+// server along with the command that was answered. PROTVER is tried first,
+// followed by the legacy NETVER command for servers that predate it.
+func (c *Client) ProtocolVersion() (version, command string, err error) {
+	command = "PROTVER"
+	v, err := c.send(typeRaw, command)
+	if isProtocolError(err) {
+		command = "NETVER"
+		v, err = c.send(typeRaw, command)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return v.(string), command, nil
+}
+
+// GetMany returns the values of the specified variables. The variables are
+// retrieved with a single LIST VAR command where possible and individual GET
+// commands are only used for any that were missing from the list.
+func (c *Client) GetMany(ups string, names []string) (map[string]string, error) {
+	variables, err := c.ListVars(ups)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, name := range names {
+		v, ok := variables[name]
+		if !ok {
+			v, err = c.Get("VAR", ups, name)
+			if err != nil {
+				return nil, err
+			}
+		}
+		values[name] = v
+	}
+	return values, nil
+}
+
+// Primary asserts that the client is the primary for the specified UPS and is
+// therefore permitted to initiate a forced shutdown. PRIMARY is tried first,
+// followed by the legacy MASTER command if the server does not recognize it.
+// ErrAccessDenied is returned if the client lacks permission.
+func (c *Client) Primary(ups string) error {
+	_, err := c.send(typeCmd, "PRIMARY", ups)
+	if errors.Is(err, ErrUnknownCommand) {
+		_, err = c.send(typeCmd, "MASTER", ups)
+	}
+	return err
+}
+
+// FSD sets the forced shutdown flag on the specified UPS, notifying the
+// secondaries that a shutdown is in progress. This normally requires that the
+// client be logged in to the UPS (see Config.LoginUPS) and have called
+// Primary. The error code from the server is returned on failure.
+func (c *Client) FSD(ups string) error {
+	_, err := c.send(typeCmd, "FSD", ups)
+	return err
+}
+
+// ListClients returns the addresses of the clients logged in to the specified
+// UPS. ErrUnknownCommand is returned by servers older than 2.7.4, which do not
+// support LIST CLIENT.
+func (c *Client) ListClients(ups string) ([]string, error) {
+	rows, err := c.List("CLIENT", ups)
+	if err != nil {
+		return nil, err
+	}
+	return parseValues(rows)
+}
+
+// Raw sends a command verbatim and returns the tokens of the response, which
+// allows use of commands that this package does not otherwise support. An
+// error is returned if the server responds with ERR. Only commands with a
+// single-line response may be used; LIST commands must use List instead.
+func (c *Client) Raw(command string) ([]string, error) {
+	v, err := c.send(typeLine, command)
+	if err != nil {
+		return nil, err
+	}
+	return v.([]string), nil
+}
@@ -1,49 +1,330 @@
 package nutclient
 
 import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
 	"time"
 )
 
+const (
+	unixPrefix  = "unix://"
+	defaultPort = "3493"
+)
+
+// Logger is implemented by types that can receive log messages from the
+// client, such as *log.Logger.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
 // Config provides a set of configuration parameters for the client and
-// callback functions that can be used for reacting to events.
+// callback functions that can be used for reacting to events. Callbacks are
+// invoked from the goroutine that owns the connection and therefore must not
+// call methods on the Client that communicate with the server.
 type Config struct {
 
 	// Addr specifies the address and port of the NUT server. If unset,
-	// "localhost:3493" is assumed.
+	// "localhost:3493" is assumed and if only the port is omitted, 3493 is
+	// used. A Unix domain socket can be used by specifying its path with a
+	// "unix://" prefix.
 	Addr string
 
+	// Addrs specifies fallback servers in the same form as Addr, which is
+	// tried first if set. When the connection is lost, the server that was
+	// last connected to is tried first, followed by each of the others in
+	// turn. This provides failover between multiple instances of upsd.
+	Addrs []string
+
 	// Name specifies the name of the UPS to monitor. If unset, "ups" is used.
 	Name string
 
+	// DialContext is used to establish the connection to the server. If unset,
+	// a net.Dialer is used.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// TLS specifies the configuration used to upgrade the connection with
+	// STARTTLS. If unset, the connection is not encrypted. Unless ServerName
+	// is set, the certificate is verified against the host portion of Addr.
+	// A client certificate can be provided with Certificates or
+	// GetClientCertificate for servers that require one.
+	TLS *tls.Config
+
+	// Username and Password specify the credentials used to authenticate with
+	// the server after each connection is established. Authentication is
+	// skipped if Username is unset.
+	Username string
+	Password string
+
+	// ReadOnly prevents the client from changing the state of any UPS. Set,
+	// InstCmd, Primary, and FSD return ErrReadOnlyClient without sending
+	// anything to the server; all other methods (including Raw) are
+	// unaffected.
+	ReadOnly bool
+
+	// LoginUPS specifies the name of a UPS to log in to after each connection
+	// is established. This registers the client with the server so that it is
+	// included in NUMLOGINS for coordinating shutdown. If unset, the client
+	// does not log in.
+	LoginUPS string
+
+	// DialTimeout specifies how long to wait for a connection to the server
+	// to be established, including when DialContext is used. If unset, the
+	// default is 10 seconds.
+	DialTimeout time.Duration
+
+	// TCPKeepAlive specifies the interval between TCP keepalive probes, which
+	// allow the operating system to detect a server that has gone away
+	// without sending any commands. It has no effect on connections other
+	// than TCP. If unset, the connection is left as it was dialed; the
+	// default dialer (used if DialContext is unset) enables probes every 15
+	// seconds.
+	TCPKeepAlive time.Duration
+
 	// ReconnectInterval specifies the duration between attempts to reconnect
 	// to the server when the connection is lost. If unset, the default is 30
 	// seconds.
 	ReconnectInterval time.Duration
 
+	// MaxReconnectInterval specifies the maximum duration between attempts to
+	// reconnect. The interval begins at ReconnectInterval and doubles after
+	// each failed attempt until it reaches this value. If unset (or less than
+	// ReconnectInterval), the interval remains fixed.
+	MaxReconnectInterval time.Duration
+
+	// StartupJitter specifies the maximum delay before the first attempt to
+	// connect. The actual delay is chosen at random so that many clients
+	// started at the same time (such as after power is restored) do not all
+	// connect to the server at once. If unset, there is no delay.
+	StartupJitter time.Duration
+
+	// MaxReconnectAttempts specifies the number of consecutive failures
+	// (counted the same way as for ErrorFn) after which the client stops
+	// trying to connect, invokes GaveUpFn, and shuts down. If unset, the
+	// client keeps trying indefinitely.
+	MaxReconnectAttempts int
+
 	// PollInterval specifies how often the status of the UPS should be polled.
 	// If unset, the default is 5 seconds.
 	PollInterval time.Duration
 
+	// CommandTimeout specifies how long to wait for the server to respond to
+	// a command. If the timeout expires, the connection is closed and
+	// reestablished. If unset, the default is 10 seconds.
+	CommandTimeout time.Duration
+
+	// WriteTimeout specifies how long to wait for a command to be sent to the
+	// server, which only takes time if the server has stopped reading. If the
+	// timeout expires, the connection is closed and reestablished. If unset,
+	// CommandTimeout is used.
+	WriteTimeout time.Duration
+
+	// CommandRetries specifies how many times a command is run again after the
+	// connection fails while running it. Each retry happens once the
+	// connection has been reestablished, so the caller may wait for as long
+	// as it takes to reconnect; the Context variants of methods can be used
+	// to limit this. Errors returned by the server are never retried. If
+	// unset, commands are not retried.
+	CommandRetries int
+
+	// MaxListRows specifies the maximum number of rows accepted in response
+	// to a LIST command, which guards against a server that never ends the
+	// list. If exceeded, the connection is closed and reestablished. If
+	// unset, the default is 10000.
+	MaxListRows int
+
+	// PoolSize specifies the number of connections used for running commands
+	// concurrently. Only the first connection polls the UPS, logs in with
+	// LoginUPS, and invokes callbacks; the others only run commands and each
+	// reconnects independently. If unset, a single connection is used.
+	PoolSize int
+
+	// Logger receives messages about connection attempts, disconnections,
+	// and failed commands. If unset, nothing is logged.
+	Logger Logger
+
+	// OnConnectProbe specifies whether VER and PROTVER should be sent after
+	// each connection is established, with the results passed to
+	// ServerInfoFn.
+	OnConnectProbe bool
+
 	// ConnectedFn is invoked every time a connection is established with the
 	// server.
 	ConnectedFn func()
 
+	// ReconnectedFn is invoked after ConnectedFn when a connection is
+	// established following the loss of a previous one. The time elapsed
+	// since the previous connection was lost is provided.
+	ReconnectedFn func(downtime time.Duration)
+
 	// DisconnectedFn is invoked every time the connection to the server is
 	// lost.
 	DisconnectedFn func()
 
-	// PowerLostFn is invoked every time line power is disconnected.
+	// DisconnectedErrFn is invoked along with DisconnectedFn and is provided
+	// the error that caused the connection to be lost. It is not invoked when
+	// the client is closed.
+	DisconnectedErrFn func(err error)
+
+	// ErrorFn is invoked every time an attempt to connect fails or an error
+	// causes the connection to be lost. The number of consecutive failures
+	// since the last successful connection (including this one) is provided;
+	// a connection only counts as successful once the client has logged in,
+	// so rejected credentials are counted as failures.
+	ErrorFn func(err error, failures int)
+
+	// GaveUpFn is invoked with the last error when the client stops trying
+	// to connect because MaxReconnectAttempts was reached. Afterwards, all
+	// commands fail as if the client was closed.
+	GaveUpFn func(lastErr error)
+
+	// ServerInfoFn is invoked with the version banner and protocol version of
+	// the server after each connection is established if OnConnectProbe is
+	// set. It is not invoked if the server does not support either command.
+	ServerInfoFn func(version, protocol string)
+
+	// OnBatteryFlags and OnLineFlags specify the flags in ups.status that
+	// indicate the UPS is running on battery and line power respectively,
+	// which is useful for UPS models that report nonstandard flags. If unset,
+	// "OB" and "OL" are used.
+	OnBatteryFlags []string
+	OnLineFlags    []string
+
+	// UseChargeTrend specifies whether the change in battery.charge should
+	// be used to decide if the UPS is running on battery when ups.status does
+	// not indicate either way, as is the case for some inexpensive models. A
+	// decreasing charge is treated as running on battery and an increasing
+	// charge as running on line power.
+	UseChargeTrend bool
+
+	// PowerLostFn is invoked every time line power is disconnected. The first
+	// status received only establishes the initial state, so this is not
+	// invoked if the UPS is already on battery when the client connects;
+	// OnBattery can be used to check for that instead.
 	PowerLostFn func()
 
 	// PowerRestoredFn is invoked every time line power is restored.
 	PowerRestoredFn func()
+
+	// ChargeThreshold specifies the battery charge (in percent) below which
+	// ChargeThresholdFn is invoked. If unset, ChargeThresholdFn is never
+	// invoked.
+	ChargeThreshold float64
+
+	// RuntimeThreshold specifies the estimated battery runtime (in seconds)
+	// below which RuntimeThresholdFn is invoked. If unset, RuntimeThresholdFn
+	// is never invoked.
+	RuntimeThreshold int
+
+	// LowBatteryFn is invoked every time the UPS reports that the battery is
+	// low (the LB flag in ups.status), including when the battery is already
+	// low when the client connects.
+	LowBatteryFn func()
+
+	// LowBatteryClearedFn is invoked every time the battery is no longer
+	// reported as low.
+	LowBatteryClearedFn func()
+
+	// ReplaceBatteryFn is invoked when the UPS reports that the battery needs
+	// to be replaced (the RB flag in ups.status), including when the flag is
+	// already set when the client connects. It is invoked again only after
+	// the flag has been cleared and set once more.
+	ReplaceBatteryFn func()
+
+	// CalibrationFn is invoked when the UPS starts or stops calibrating the
+	// battery (the CAL flag in ups.status).
+	CalibrationFn func(active bool)
+
+	// IgnoreCalibration specifies whether the UPS should continue to be
+	// considered running on line power while it calibrates the battery, since
+	// it does so intentionally. PowerLostFn is then not invoked because of a
+	// calibration.
+	IgnoreCalibration bool
+
+	// Debounce is the length of time that a change between line and battery
+	// power must persist before PowerLostFn or PowerRestoredFn is invoked. A
+	// change that is reversed within this time is ignored, which prevents
+	// brief glitches from being reported. If zero, changes are reported as
+	// soon as they are polled.
+	Debounce time.Duration
+
+	// StatusChangedFn is invoked every time the value of ups.status changes,
+	// such as from "OL" to "OL CHRG". The first value received is reported
+	// as a change from "".
+	StatusChangedFn func(old, new string)
+
+	// HistorySize specifies the number of changes to ups.status retained for
+	// History. If unset, no history is kept.
+	HistorySize int
+
+	// WatchVars specifies the names of variables to monitor for changes with
+	// VarChangedFn.
+	WatchVars []string
+
+	// VarChangedFn is invoked every time the value of one of the variables in
+	// WatchVars changes. The first value received for each is also reported.
+	VarChangedFn func(name, value string)
+
+	// BatteryChargeFn is invoked with the battery charge (in percent) each
+	// time the UPS is polled, provided the UPS reports it.
+	BatteryChargeFn func(percent float64)
+
+	// ChargeThresholdFn is invoked once when the battery charge drops below
+	// ChargeThreshold. It will not be invoked again until the charge has
+	// risen back above the threshold.
+	ChargeThresholdFn func(percent float64)
+
+	// RuntimeFn is invoked with the estimated battery runtime (in seconds)
+	// each time the UPS is polled, provided the UPS reports it.
+	RuntimeFn func(seconds int)
+
+	// RuntimeThresholdFn is invoked once when the estimated battery runtime
+	// drops below RuntimeThreshold. It will not be invoked again until the
+	// runtime has risen back above the threshold.
+	RuntimeThresholdFn func(seconds int)
+}
+
+func normalizeAddr(addr string) string {
+	if addr == "" {
+		return net.JoinHostPort("localhost", defaultPort)
+	}
+	if strings.HasPrefix(addr, unixPrefix) {
+		return addr
+	}
+
+	// Use the default port if none was specified
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+		return net.JoinHostPort(host, defaultPort)
+	}
+	return addr
+}
+
+func (c *Config) getAddrs() []string {
+	addrs := []string{}
+	if c.Addr != "" || len(c.Addrs) == 0 {
+		addrs = append(addrs, normalizeAddr(c.Addr))
+	}
+	for _, addr := range c.Addrs {
+		addrs = append(addrs, normalizeAddr(addr))
+	}
+	return addrs
 }
 
-func (c *Config) getAddr() string {
-	if c.Addr == "" {
-		return "localhost:3493"
+func splitNetworkAddr(addr string) (string, string) {
+	if strings.HasPrefix(addr, unixPrefix) {
+		return "unix", strings.TrimPrefix(addr, unixPrefix)
 	}
-	return c.Addr
+	return "tcp", addr
+}
+
+func getHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
 }
 
 func (c *Config) getName() string {
@@ -53,6 +334,13 @@ func (c *Config) getName() string {
 	return c.Name
 }
 
+func (c *Config) getDialTimeout() time.Duration {
+	if c.DialTimeout == 0 {
+		return 10 * time.Second
+	}
+	return c.DialTimeout
+}
+
 func (c *Config) getReconnectInterval() time.Duration {
 	if c.ReconnectInterval == 0 {
 		return 30 * time.Second
@@ -60,9 +348,51 @@ func (c *Config) getReconnectInterval() time.Duration {
 	return c.ReconnectInterval
 }
 
+func (c *Config) getMaxReconnectInterval() time.Duration {
+	if c.MaxReconnectInterval < c.getReconnectInterval() {
+		return c.getReconnectInterval()
+	}
+	return c.MaxReconnectInterval
+}
+
+func (c *Config) getWriteTimeout() time.Duration {
+	if c.WriteTimeout == 0 {
+		return c.getCommandTimeout()
+	}
+	return c.WriteTimeout
+}
+
+func (c *Config) getOnBatteryFlags() []string {
+	if len(c.OnBatteryFlags) == 0 {
+		return []string{"OB"}
+	}
+	return c.OnBatteryFlags
+}
+
+func (c *Config) getOnLineFlags() []string {
+	if len(c.OnLineFlags) == 0 {
+		return []string{"OL"}
+	}
+	return c.OnLineFlags
+}
+
+func (c *Config) getMaxListRows() int {
+	if c.MaxListRows == 0 {
+		return 10000
+	}
+	return c.MaxListRows
+}
+
 func (c *Config) getPollInterval() time.Duration {
 	if c.PollInterval == 0 {
 		return 5 * time.Second
 	}
 	return c.PollInterval
 }
+
+func (c *Config) getCommandTimeout() time.Duration {
+	if c.CommandTimeout == 0 {
+		return 10 * time.Second
+	}
+	return c.CommandTimeout
+}
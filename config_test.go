@@ -0,0 +1,78 @@
+package nutclient
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetAddr(t *testing.T) {
+	for _, v := range []struct {
+		input  string
+		output string
+	}{
+		{input: "", output: "localhost:3493"},
+		{input: "host", output: "host:3493"},
+		{input: "host:1234", output: "host:1234"},
+		{input: "[::1]", output: "[::1]:3493"},
+		{input: "[::1]:3493", output: "[::1]:3493"},
+		{input: "::1", output: "[::1]:3493"},
+		{input: "unix:///run/nut/upsd.sock", output: "unix:///run/nut/upsd.sock"},
+	} {
+		if output := normalizeAddr(v.input); output != v.output {
+			t.Fatalf("%#v: %#v != %#v", v.input, v.output, output)
+		}
+	}
+}
+
+func TestGetAddrs(t *testing.T) {
+	for _, v := range []struct {
+		name   string
+		cfg    *Config
+		output []string
+	}{
+		{
+			name:   "default",
+			cfg:    &Config{},
+			output: []string{"localhost:3493"},
+		},
+		{
+			name: "addr and fallbacks",
+			cfg: &Config{
+				Addr:  "host1",
+				Addrs: []string{"host2"},
+			},
+			output: []string{"host1:3493", "host2:3493"},
+		},
+		{
+			name: "fallbacks only",
+			cfg: &Config{
+				Addrs: []string{"host1", "host2"},
+			},
+			output: []string{"host1:3493", "host2:3493"},
+		},
+	} {
+		if output := v.cfg.getAddrs(); !reflect.DeepEqual(v.output, output) {
+			t.Fatalf("%s: %#v != %#v", v.name, v.output, output)
+		}
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("NUT_ADDR", "upsd:3493")
+	t.Setenv("NUT_UPS", "ups2")
+	t.Setenv("NUT_USERNAME", "admin")
+	t.Setenv("NUT_PASSWORD", "password")
+	t.Setenv("NUT_POLL_INTERVAL", "10s")
+	t.Setenv("NUT_RECONNECT_INTERVAL", "invalid")
+	v := &Config{
+		Addr:         "upsd:3493",
+		Name:         "ups2",
+		Username:     "admin",
+		Password:     "password",
+		PollInterval: 10 * time.Second,
+	}
+	if cfg := ConfigFromEnv(); !reflect.DeepEqual(v, cfg) {
+		t.Fatalf("%#v != %#v", v, cfg)
+	}
+}
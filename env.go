@@ -0,0 +1,40 @@
+package nutclient
+
+import (
+	"os"
+	"time"
+)
+
+// ConfigFromEnv returns a Config populated from the following environment
+// variables:
+//
+//	NUT_ADDR                Addr
+//	NUT_UPS                 Name
+//	NUT_USERNAME            Username
+//	NUT_PASSWORD            Password
+//	NUT_POLL_INTERVAL       PollInterval (such as "5s")
+//	NUT_RECONNECT_INTERVAL  ReconnectInterval
+//	NUT_COMMAND_TIMEOUT     CommandTimeout
+//
+// Fields for variables that are unset (or contain a duration that cannot be
+// parsed) are left empty so that the usual defaults apply. Any field can be
+// changed before the Config is passed to New, which takes precedence over the
+// environment.
+func ConfigFromEnv() *Config {
+	duration := func(name string) time.Duration {
+		d, err := time.ParseDuration(os.Getenv(name))
+		if err != nil {
+			return 0
+		}
+		return d
+	}
+	return &Config{
+		Addr:              os.Getenv("NUT_ADDR"),
+		Name:              os.Getenv("NUT_UPS"),
+		Username:          os.Getenv("NUT_USERNAME"),
+		Password:          os.Getenv("NUT_PASSWORD"),
+		PollInterval:      duration("NUT_POLL_INTERVAL"),
+		ReconnectInterval: duration("NUT_RECONNECT_INTERVAL"),
+		CommandTimeout:    duration("NUT_COMMAND_TIMEOUT"),
+	}
+}
@@ -0,0 +1,147 @@
+package nutclient
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/maps"
+)
+
+var (
+
+	// ErrAccessDenied indicates that the client is not permitted to perform
+	// the requested operation.
+	ErrAccessDenied = &ProtocolError{Code: "ACCESS-DENIED"}
+
+	// ErrUnknownUPS indicates that the server does not know of the UPS.
+	ErrUnknownUPS = &ProtocolError{Code: "UNKNOWN-UPS"}
+
+	// ErrVarNotSupported indicates that the UPS does not support the variable.
+	ErrVarNotSupported = &ProtocolError{Code: "VAR-NOT-SUPPORTED"}
+
+	// ErrCmdNotSupported indicates that the UPS does not support the instant
+	// command.
+	ErrCmdNotSupported = &ProtocolError{Code: "CMD-NOT-SUPPORTED"}
+
+	// ErrInvalidArgument indicates that the command was malformed.
+	ErrInvalidArgument = &ProtocolError{Code: "INVALID-ARGUMENT"}
+
+	// ErrUnknownCommand indicates that the server does not recognize the
+	// command, usually because it predates it.
+	ErrUnknownCommand = &ProtocolError{Code: "UNKNOWN-COMMAND"}
+
+	// ErrReadOnly indicates that the variable cannot be changed.
+	ErrReadOnly = &ProtocolError{Code: "READONLY"}
+
+	// ErrDriverNotConnected indicates that the server is not connected to the
+	// driver for the UPS.
+	ErrDriverNotConnected = &ProtocolError{Code: "DRIVER-NOT-CONNECTED"}
+)
+
+// ErrServerClosed indicates that the server closed the connection, such as
+// when upsd is restarted, before responding to a command.
+var ErrServerClosed = errors.New("connection closed by server")
+
+// ErrReadOnlyClient is returned by methods that change the state of a UPS
+// when Config.ReadOnly is set.
+var ErrReadOnlyClient = errors.New("client is read-only")
+
+// ProtocolError is returned when the server responds to a command with ERR.
+// Errors with a known code can be matched against the values above using
+// errors.Is.
+type ProtocolError struct {
+
+	// Code is the error code returned by the server, such as "UNKNOWN-UPS".
+	Code string
+}
+
+func (p *ProtocolError) Error() string {
+	return fmt.Sprintf("server returned %s", p.Code)
+}
+
+// Is returns true if target is a ProtocolError with the same code.
+func (p *ProtocolError) Is(target error) bool {
+	t, ok := target.(*ProtocolError)
+	return ok && t.Code == p.Code
+}
+
+// ConnectError is passed to ErrorFn when an attempt to connect to the server
+// fails. The underlying error can be examined with errors.Is and errors.As.
+type ConnectError struct {
+
+	// Addr is the address that the client attempted to connect to.
+	Addr string
+
+	// Err is the error returned when connecting.
+	Err error
+}
+
+func (c *ConnectError) Error() string {
+	return fmt.Sprintf("unable to connect to %s: %s", c.Addr, c.Err)
+}
+
+// Unwrap returns the underlying error.
+func (c *ConnectError) Unwrap() error {
+	return c.Err
+}
+
+// ListAllError is returned by ListAll when the variables for one or more
+// UPS could not be listed.
+type ListAllError struct {
+
+	// Errors contains the error for each UPS, keyed by its name.
+	Errors map[string]error
+}
+
+// names returns the names of the UPS with errors in sorted order.
+func (l *ListAllError) names() []string {
+	names := maps.Keys(l.Errors)
+	sort.Strings(names)
+	return names
+}
+
+func (l *ListAllError) Error() string {
+	v := []string{}
+	for _, name := range l.names() {
+		v = append(v, fmt.Sprintf("%s: %s", name, l.Errors[name]))
+	}
+	return fmt.Sprintf("unable to list variables for %s", strings.Join(v, ", "))
+}
+
+// Is reports whether the error for any UPS matches target. This is used
+// instead of a multi-error Unwrap, which requires Go 1.20.
+func (l *ListAllError) Is(target error) bool {
+	for _, err := range l.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error for a UPS (in order of name) that matches target.
+func (l *ListAllError) As(target any) bool {
+	for _, name := range l.names() {
+		if errors.As(l.Errors[name], target) {
+			return true
+		}
+	}
+	return false
+}
+
+// isProtocolError returns true if the server responded with an error, which
+// (unlike other errors) leaves the connection usable.
+func isProtocolError(err error) bool {
+	var p *ProtocolError
+	return errors.As(err, &p)
+}
+
+// ignoreProtocolError returns nil if the server responded with an error.
+func ignoreProtocolError(err error) error {
+	if isProtocolError(err) {
+		return nil
+	}
+	return err
+}
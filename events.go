@@ -0,0 +1,59 @@
+package nutclient
+
+import (
+	"time"
+)
+
+// eventBufferSize is the number of events that can be queued on the channel
+// returned by Events before new events are discarded.
+const eventBufferSize = 32
+
+// EventType indicates the type of an Event.
+type EventType int
+
+const (
+	// Connected indicates that a connection was established with the server.
+	Connected EventType = iota
+
+	// Disconnected indicates that the connection to the server was lost.
+	Disconnected
+
+	// PowerLost indicates that line power was disconnected.
+	PowerLost
+
+	// PowerRestored indicates that line power was restored.
+	PowerRestored
+
+	// LowBattery indicates that the battery charge became low.
+	LowBattery
+
+	// LowBatteryCleared indicates that the battery charge is no longer low.
+	LowBatteryCleared
+
+	// ReplaceBattery indicates that the battery needs to be replaced.
+	ReplaceBattery
+)
+
+// Event describes a change in the state of the connection or the UPS.
+type Event struct {
+	Type EventType
+	Time time.Time
+}
+
+func (c *Client) emit(t EventType) {
+	select {
+	case c.eventChan <- Event{Type: t, Time: c.clock.Now()}:
+	default:
+	}
+}
+
+// Events returns a channel that receives an Event each time the client
+// connects or disconnects, line power is lost or restored, the battery becomes
+// low or recovers, or the battery needs replacing (the EventType values
+// above). Other callbacks in Config, such as StatusChangedFn, have no
+// corresponding event. The channel is buffered; events that arrive while it
+// is full are discarded, so it should be read promptly. The channel is closed
+// when the client is closed.
+func (c *Client) Events() <-chan Event {
+	return c.eventChan
+}
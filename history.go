@@ -0,0 +1,40 @@
+package nutclient
+
+import (
+	"time"
+)
+
+// StatusEvent describes a change to the value of ups.status.
+type StatusEvent struct {
+	Old  string
+	New  string
+	Time time.Time
+}
+
+// record adds a change to the history, discarding the oldest change if the
+// history is full.
+func (c *Client) record(old, new string) {
+	if c.cfg.HistorySize <= 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.history) == c.cfg.HistorySize {
+		c.history = c.history[1:]
+	}
+	c.history = append(c.history, StatusEvent{
+		Old:  old,
+		New:  new,
+		Time: c.clock.Now(),
+	})
+}
+
+// History returns the most recent changes to ups.status, oldest first. The
+// number of changes retained is set by HistorySize.
+func (c *Client) History() []StatusEvent {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	history := make([]StatusEvent, len(c.history))
+	copy(history, c.history)
+	return history
+}
@@ -0,0 +1,49 @@
+//go:build go1.23
+
+package nutclient
+
+import (
+	"context"
+	"iter"
+)
+
+// ListSeq is identical to List but yields each row as it is received instead
+// of returning them all at once. The iteration can be stopped early; the rest
+// of the list is still read from the server (and discarded) so that the
+// connection remains usable. Since the entire list must be received within
+// CommandTimeout, each row should be processed promptly. If an error occurs,
+// it is yielded with a nil row and the iteration ends.
+//
+// The connection is busy until the iteration ends, so the loop body must not
+// call other methods on the same Client; doing so blocks forever unless
+// PoolSize provides another connection to run the command on.
+func (c *Client) ListSeq(args ...string) iter.Seq2[[]string, error] {
+	return func(yield func([]string, error) bool) {
+		r := &cmdRequest{
+			ctx:          context.Background(),
+			cmdType:      typeListSeq,
+			args:         args,
+			responseChan: make(chan *cmdResponse, 1),
+			rowChan:      make(chan []string),
+			stopChan:     make(chan any),
+		}
+		defer close(r.stopChan)
+		if err := c.submit(r); err != nil {
+			yield(nil, err)
+			return
+		}
+		for {
+			select {
+			case row := <-r.rowChan:
+				if !yield(row, nil) {
+					return
+				}
+			case v := <-r.responseChan:
+				if v.err != nil {
+					yield(nil, v.err)
+				}
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+//go:build go1.23
+
+package nutclient
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestListSeq(t *testing.T) {
+	c := newTestClient(t, &Config{
+		Addr: newTestServer(t, map[string]string{
+			"LIST VAR ups": testStatus,
+			"LIST VAR ups2": `BEGIN LIST VAR ups2
+VAR ups2 ups.status "OL"
+VAR ups2 ups.mfr "American Power Conversion"
+VAR ups2 battery.charge "100"
+END LIST VAR ups2`,
+			"VER": "upsd",
+		}),
+	})
+	rows := [][]string{}
+	for row, err := range c.ListSeq("VAR", "ups2") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	v := [][]string{
+		{"ups.status", "OL"},
+		{"ups.mfr", "American Power Conversion"},
+		{"battery.charge", "100"},
+	}
+	if !reflect.DeepEqual(v, rows) {
+		t.Fatalf("%#v != %#v", v, rows)
+	}
+
+	// Stopping early must leave the connection ready for the next command
+	for row, err := range c.ListSeq("VAR", "ups2") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row[0] == "ups.status" {
+			break
+		}
+	}
+	if v, err := c.Version(); err != nil || v != "upsd" {
+		t.Fatalf("%#v != %#v (%v)", "upsd", v, err)
+	}
+
+	// Errors from the server end the iteration
+	errs := []error{}
+	for row, err := range c.ListSeq("VAR", "ups3") {
+		if row != nil {
+			t.Fatalf("%#v != nil", row)
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("%#v != %#v", 1, len(errs))
+	}
+	if !errors.Is(errs[0], ErrUnknownCommand) {
+		t.Fatalf("%#v != %#v", ErrUnknownCommand, errs[0])
+	}
+}
+
+func TestListSeqClose(t *testing.T) {
+	var (
+		c = newTestClient(t, &Config{
+			Addr: newTestServer(t, map[string]string{
+				"LIST VAR ups": testStatus,
+				"LIST VAR ups2": `BEGIN LIST VAR ups2
+VAR ups2 ups.status "OL"
+VAR ups2 battery.charge "100"
+END LIST VAR ups2`,
+			}),
+		})
+		rowChan     = make(chan any)
+		unblockChan = make(chan any)
+		closedChan  = make(chan any)
+	)
+	defer close(unblockChan)
+
+	// Stall the consumer on the first row so that the second cannot be sent
+	go func() {
+		for range c.ListSeq("VAR", "ups2") {
+			rowChan <- nil
+			<-unblockChan
+		}
+	}()
+	<-rowChan
+	go func() {
+		c.Close()
+		close(closedChan)
+	}()
+	select {
+	case <-closedChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked by a stalled consumer")
+	}
+}
@@ -0,0 +1,121 @@
+package nutclient
+
+import (
+	"strconv"
+	"time"
+)
+
+// Metrics provides a snapshot of commonly-used values from the last time the
+// UPS was polled. Values not reported by the UPS are left at zero.
+type Metrics struct {
+	OnBattery      bool
+	BatteryCharge  float64
+	RuntimeSeconds int
+	InputVoltage   float64
+	LastPollTime   time.Time
+}
+
+func newMetrics(variables map[string]string, onBattery bool, now time.Time) Metrics {
+	m := Metrics{
+		OnBattery:    onBattery,
+		LastPollTime: now,
+	}
+	m.BatteryCharge, _ = strconv.ParseFloat(variables["battery.charge"], 64)
+	m.RuntimeSeconds, _ = strconv.Atoi(variables["battery.runtime"])
+	m.InputVoltage, _ = strconv.ParseFloat(variables["input.voltage"], 64)
+	return m
+}
+
+// Metrics returns a snapshot of the values from the last time the UPS was
+// polled. This is useful for exporting the values to a monitoring system.
+func (c *Client) Metrics() Metrics {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.metrics
+}
+
+// Snapshot provides the values of the variables most commonly needed for
+// displaying the state of a UPS.
+type Snapshot struct {
+	Status         string
+	BatteryCharge  float64
+	BatteryRuntime int
+	Load           float64
+	InputVoltage   float64
+
+	// Missing contains the names of any of the variables above that the UPS
+	// did not report (or reported with a value that could not be parsed).
+	Missing []string
+}
+
+func newSnapshot(variables map[string]string) Snapshot {
+	var (
+		s       = Snapshot{}
+		missing = func(name string) {
+			s.Missing = append(s.Missing, name)
+		}
+		parseFloat = func(name string) float64 {
+			v, err := strconv.ParseFloat(variables[name], 64)
+			if err != nil {
+				missing(name)
+			}
+			return v
+		}
+	)
+	if v, ok := variables["ups.status"]; ok {
+		s.Status = v
+	} else {
+		missing("ups.status")
+	}
+	s.BatteryCharge = parseFloat("battery.charge")
+	v, err := strconv.Atoi(variables["battery.runtime"])
+	if err != nil {
+		missing("battery.runtime")
+	}
+	s.BatteryRuntime = v
+	s.Load = parseFloat("ups.load")
+	s.InputVoltage = parseFloat("input.voltage")
+	return s
+}
+
+// Snapshot retrieves the status, battery charge, battery runtime, load, and
+// input voltage of the specified UPS with a single LIST VAR command.
+func (c *Client) Snapshot(ups string) (Snapshot, error) {
+	variables, err := c.ListVars(ups)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return newSnapshot(variables), nil
+}
+
+// Stats provides counters for the connection to the server.
+type Stats struct {
+
+	// ConnectCount is the number of times a connection was established.
+	ConnectCount int
+
+	// DisconnectCount is the number of times the connection was lost (not
+	// including when the client was closed).
+	DisconnectCount int
+
+	// CommandCount is the number of commands sent to the server (not
+	// including those used for polling) and CommandErrors is the number of
+	// those that failed.
+	CommandCount  int
+	CommandErrors int
+
+	// CurrentUptime is how long the current connection has been established
+	// or zero if the client is not connected.
+	CurrentUptime time.Duration
+}
+
+// Stats returns a snapshot of the counters for the connection to the server.
+func (c *Client) Stats() Stats {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	s := c.stats
+	if !c.connectedAt.IsZero() {
+		s.CurrentUptime = c.clock.Now().Sub(c.connectedAt)
+	}
+	return s
+}
@@ -0,0 +1,223 @@
+// Package nuttest provides a NUT server for testing code that uses nutclient
+// without requiring a real upsd.
+package nuttest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Handler replies to a command, which is provided split into its arguments
+// (with any quotes removed). ok is false if the handler does not recognize
+// the command, in which case the next handler is tried. The response may
+// contain multiple lines.
+type Handler func(args []string) (response string, ok bool)
+
+// Server is a NUT server listening on a local TCP port.
+type Server struct {
+
+	// Addr is the address of the server, suitable for Config.Addr.
+	Addr string
+
+	listener net.Listener
+	handlers []Handler
+	wg       sync.WaitGroup
+	mutex    sync.Mutex
+	conns    map[net.Conn]any
+}
+
+// NewServer starts a server that replies to each command using the first
+// handler that recognizes it. Commands that no handler recognizes receive
+// "ERR UNKNOWN-COMMAND". The server should be closed with Close when no
+// longer needed.
+func NewServer(handlers ...Handler) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("nuttest: unable to listen: %s", err))
+	}
+	s := &Server{
+		Addr:     l.Addr().String(),
+		listener: l,
+		handlers: handlers,
+		conns:    map[net.Conn]any{},
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+func (s *Server) run() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.conns[conn] = nil
+		s.mutex.Unlock()
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		conn.Close()
+	}()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		args := split(scanner.Text())
+		response := s.handle(args)
+		if _, err := conn.Write([]byte(response + "\n")); err != nil {
+			return
+		}
+		if len(args) > 0 && strings.EqualFold(args[0], "LOGOUT") {
+			return
+		}
+	}
+}
+
+func (s *Server) handle(args []string) string {
+	for _, h := range s.handlers {
+		if response, ok := h(args); ok {
+			return response
+		}
+	}
+	if len(args) > 0 && strings.EqualFold(args[0], "LOGOUT") {
+		return "OK Goodbye"
+	}
+	return "ERR UNKNOWN-COMMAND"
+}
+
+// Close stops the server and closes any open connections.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mutex.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+	s.wg.Wait()
+}
+
+// Respond returns a Handler that replies to a command matching the provided
+// line (after quotes are removed) with response.
+func Respond(command, response string) Handler {
+	want := split(command)
+	return func(args []string) (string, bool) {
+		if len(args) != len(want) {
+			return "", false
+		}
+		for i := range args {
+			if args[i] != want[i] {
+				return "", false
+			}
+		}
+		return response, true
+	}
+}
+
+// UPS returns a Handler that provides a UPS with the specified variables. It
+// replies to LIST UPS, LIST VAR, GET VAR, and INSTCMD (accepting any command
+// in commands). Variables are listed in alphabetical order.
+func UPS(name string, vars map[string]string, commands ...string) Handler {
+	names := []string{}
+	for k := range vars {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return func(args []string) (string, bool) {
+		switch {
+		case matches(args, "LIST", "UPS"):
+			return fmt.Sprintf(
+				"BEGIN LIST UPS\nUPS %s %s\nEND LIST UPS",
+				name,
+				quote(name),
+			), true
+		case matches(args, "LIST", "VAR", name):
+			lines := []string{fmt.Sprintf("BEGIN LIST VAR %s", name)}
+			for _, k := range names {
+				lines = append(lines, fmt.Sprintf("VAR %s %s %s", name, k, quote(vars[k])))
+			}
+			lines = append(lines, fmt.Sprintf("END LIST VAR %s", name))
+			return strings.Join(lines, "\n"), true
+		case len(args) == 4 && matches(args[:3], "GET", "VAR", name):
+			v, ok := vars[args[3]]
+			if !ok {
+				return "ERR VAR-NOT-SUPPORTED", true
+			}
+			return fmt.Sprintf("VAR %s %s %s", name, args[3], quote(v)), true
+		case len(args) == 3 && matches(args[:2], "INSTCMD", name):
+			for _, c := range commands {
+				if c == args[2] {
+					return "OK", true
+				}
+			}
+			return "ERR CMD-NOT-SUPPORTED", true
+		}
+		return "", false
+	}
+}
+
+func matches(args []string, want ...string) bool {
+	if len(args) != len(want) {
+		return false
+	}
+	for i := range args {
+		if !strings.EqualFold(args[i], want[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func quote(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return fmt.Sprintf(`"%s"`, v)
+}
+
+// split separates a command into its arguments, removing quotes and escapes.
+func split(line string) []string {
+	var (
+		args     = []string{}
+		arg      strings.Builder
+		inArg    bool
+		inQuotes bool
+		escaped  bool
+	)
+	for _, r := range line {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case inQuotes && r == '\\':
+			escaped = true
+		case r == '"':
+			inQuotes = !inQuotes
+			inArg = true
+		case !inQuotes && (r == ' ' || r == '\t' || r == '\r'):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
@@ -0,0 +1,78 @@
+package nuttest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/nathan-osman/nutclient"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer(
+		Respond("VER", "Network UPS Tools upsd 2.8.0"),
+		UPS("ups", map[string]string{
+			"ups.status":     "OL",
+			"ups.mfr":        `The "Best" UPS`,
+			"battery.charge": "100",
+		}, "beeper.toggle"),
+	)
+	defer s.Close()
+	connectedChan := make(chan any, 1)
+	c := nutclient.New(&nutclient.Config{
+		Addr: s.Addr,
+		ConnectedFn: func() {
+			connectedChan <- nil
+		},
+	})
+	defer c.Close()
+	<-connectedChan
+	v, err := c.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "Network UPS Tools upsd 2.8.0" {
+		t.Fatalf("%#v != %#v", "Network UPS Tools upsd 2.8.0", v)
+	}
+	vars, err := c.ListVars("ups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantVars := map[string]string{
+		"ups.status":     "OL",
+		"ups.mfr":        `The "Best" UPS`,
+		"battery.charge": "100",
+	}
+	if !reflect.DeepEqual(wantVars, vars) {
+		t.Fatalf("%#v != %#v", wantVars, vars)
+	}
+	if _, err := c.GetVar("ups", "input.voltage"); !errors.Is(err, nutclient.ErrVarNotSupported) {
+		t.Fatalf("%#v != %#v", nutclient.ErrVarNotSupported, err)
+	}
+	if err := c.InstCmd("ups", "beeper.toggle"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ListUPS(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.FSD("ups"); !errors.Is(err, nutclient.ErrUnknownCommand) {
+		t.Fatalf("%#v != %#v", nutclient.ErrUnknownCommand, err)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	for _, v := range []struct {
+		input  string
+		output []string
+	}{
+		{input: "", output: []string{}},
+		{input: "LIST VAR ups", output: []string{"LIST", "VAR", "ups"}},
+		{input: `SET VAR ups ups.id "my ups"`, output: []string{"SET", "VAR", "ups", "ups.id", "my ups"}},
+		{input: `SET VAR ups ups.id "a \"b\""`, output: []string{"SET", "VAR", "ups", "ups.id", `a "b"`}},
+		{input: `SET VAR ups ups.id ""`, output: []string{"SET", "VAR", "ups", "ups.id", ""}},
+	} {
+		if output := split(v.input); !reflect.DeepEqual(v.output, output) {
+			t.Fatalf("%#v: %#v != %#v", v.input, v.output, output)
+		}
+	}
+}
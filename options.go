@@ -0,0 +1,58 @@
+package nutclient
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// Option sets a configuration parameter for a client created with
+// NewWithOptions.
+type Option func(*Config)
+
+// WithName sets the name of the UPS to monitor.
+func WithName(name string) Option {
+	return func(c *Config) {
+		c.Name = name
+	}
+}
+
+// WithReconnectInterval sets the duration between attempts to reconnect.
+func WithReconnectInterval(d time.Duration) Option {
+	return func(c *Config) {
+		c.ReconnectInterval = d
+	}
+}
+
+// WithPollInterval sets how often the status of the UPS is polled.
+func WithPollInterval(d time.Duration) Option {
+	return func(c *Config) {
+		c.PollInterval = d
+	}
+}
+
+// WithCredentials sets the username and password used to authenticate.
+func WithCredentials(username, password string) Option {
+	return func(c *Config) {
+		c.Username = username
+		c.Password = password
+	}
+}
+
+// WithTLS sets the configuration used to upgrade the connection with
+// STARTTLS.
+func WithTLS(cfg *tls.Config) Option {
+	return func(c *Config) {
+		c.TLS = cfg
+	}
+}
+
+// NewWithOptions creates a new Client instance for the server at addr,
+// configured with the provided options. Any field of Config that does not
+// have an option can still be set by using New instead.
+func NewWithOptions(addr string, opts ...Option) *Client {
+	cfg := &Config{Addr: addr}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return New(cfg)
+}
@@ -0,0 +1,33 @@
+package nutclient
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	tlsConfig := &tls.Config{}
+	c := NewWithOptions(
+		"127.0.0.1:1",
+		WithName("ups2"),
+		WithReconnectInterval(time.Minute),
+		WithPollInterval(time.Second),
+		WithCredentials("user", "pass"),
+		WithTLS(tlsConfig),
+	)
+	defer c.Close()
+	v := &Config{
+		Addr:              "127.0.0.1:1",
+		Name:              "ups2",
+		ReconnectInterval: time.Minute,
+		PollInterval:      time.Second,
+		Username:          "user",
+		Password:          "pass",
+		TLS:               tlsConfig,
+	}
+	if !reflect.DeepEqual(v, c.cfg) {
+		t.Fatalf("%#v != %#v", v, c.cfg)
+	}
+}
@@ -3,18 +3,28 @@ package nutclient
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"io"
 	"strings"
 )
 
+// maxLineSize is the maximum length of a single line in a response; this is
+// well beyond anything a real server sends but guards against an unbounded
+// buffer.
+const maxLineSize = 1024 * 1024
+
 var (
 	errMissingEndQuote = errors.New("missing \"")
 
 	errBeginListMissing = errors.New("BEGIN LIST expected")
-	errVarExpected      = errors.New("VAR expected")
+	errRowExpected      = errors.New("list row expected")
+	errOkExpected       = errors.New("OK expected")
+	errValueMissing     = errors.New("value expected")
+	errPrefixMismatch   = errors.New("response does not match command")
 	errVarNameMissing   = errors.New("variable name expected")
 	errVarValueMissing  = errors.New("variable value expected")
 	errUnexpectedEof    = errors.New("unexpected EOF")
+	errTooManyRows      = errors.New("too many rows in list")
 )
 
 func isSpace(b byte) bool {
@@ -35,11 +45,17 @@ func split(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		return
 	}
 
-	// If the next character is an open quote, read until end quote or EOF
+	// If the next character is an open quote, read until end quote or EOF;
+	// a backslash escapes the character that follows it
 	if data[advance] == '"' {
 		advance++
 		foundQuote := false
 		for ; advance < len(data); advance++ {
+			if data[advance] == '\\' && advance+1 < len(data) {
+				advance++
+				token = append(token, data[advance])
+				continue
+			}
 			if data[advance] == '"' {
 				foundQuote = true
 				break
@@ -66,67 +82,200 @@ func split(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	return
 }
 
+// quote wraps a value in quotes so that it is read as a single token,
+// escaping any quotes or backslashes it contains.
+func quote(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	return fmt.Sprintf("\"%s\"", v)
+}
+
+// formatCommand joins the arguments of a command, quoting any that would not
+// otherwise be read as a single token.
+func formatCommand(args []string) string {
+	v := make([]string, len(args))
+	for i, a := range args {
+		if len(a) == 0 || strings.ContainsAny(a, " \t\r\n\"\\") {
+			a = quote(a)
+		}
+		v[i] = a
+	}
+	return strings.Join(v, " ")
+}
+
+// tokenize splits a single line of a response into its tokens.
+func tokenize(line string) ([]string, error) {
+	s := bufio.NewScanner(strings.NewReader(line))
+	s.Buffer(nil, maxLineSize)
+	s.Split(split)
+	tokens := []string{}
+	for s.Scan() {
+		if t := s.Text(); len(t) != 0 {
+			tokens = append(tokens, t)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// trimPrefix checks that the tokens begin with the provided prefix (ignoring
+// case) and returns the tokens that follow it.
+func trimPrefix(tokens []string, prefix ...string) ([]string, bool) {
+	if len(tokens) < len(prefix) {
+		return nil, false
+	}
+	for i, p := range prefix {
+		if !strings.EqualFold(tokens[i], p) {
+			return nil, false
+		}
+	}
+	return tokens[len(prefix):], true
+}
+
 type baseReader struct {
 	scanner *bufio.Scanner
+	tokens  []string
 }
 
-func (b *baseReader) next() bool {
-	if !b.scanner.Scan() {
-		return false
-	}
-	return len(b.scanner.Text()) != 0
+// init prepares the scanner for reading lines. Lines end with "\n" and any
+// "\r" preceding it is removed, so responses with CRLF line endings (as some
+// proxies send) are read the same way; tokenize therefore never sees the line
+// terminator.
+func (b *baseReader) init(r io.Reader) {
+	b.scanner = bufio.NewScanner(r)
+	b.scanner.Buffer(nil, maxLineSize)
+	b.scanner.Split(bufio.ScanLines)
 }
 
-func (b *baseReader) isKeyword(v string) bool {
-	return strings.ToLower(b.scanner.Text()) == v
+// scan reads the next line of the response, returning the error that caused
+// reading to fail (such as a timeout) if there is no line. If the end of the
+// input was reached, the server must have closed the connection.
+func (b *baseReader) scan() error {
+	if !b.scanner.Scan() {
+		if err := b.scanner.Err(); err != nil {
+			return err
+		}
+		return ErrServerClosed
+	}
+	return nil
 }
 
-func (b *baseReader) expectKeyword(v string) bool {
-	return b.next() && b.isKeyword(v)
+func (b *baseReader) next() error {
+	if err := b.scan(); err != nil {
+		return err
+	}
+	tokens, err := tokenize(b.scanner.Text())
+	if err != nil {
+		return err
+	}
+	if v, ok := trimPrefix(tokens, "err"); ok {
+		if len(v) == 0 {
+			return errUnexpectedEof
+		}
+		return &ProtocolError{Code: v[0]}
+	}
+	b.tokens = tokens
+	return nil
 }
 
 type responseReader interface {
 	parse(io.Reader) error
 }
 
+type lineReader struct {
+	baseReader
+}
+
+func (l *lineReader) parse(r io.Reader) error {
+	l.init(r)
+	return l.next()
+}
+
+type rawReader struct {
+	baseReader
+	line string
+}
+
+func (r *rawReader) parse(rd io.Reader) error {
+	r.init(rd)
+	if err := r.scan(); err != nil {
+		return err
+	}
+	r.line = r.scanner.Text()
+	if f := strings.Fields(r.line); len(f) > 1 && strings.EqualFold(f[0], "err") {
+		return &ProtocolError{Code: f[1]}
+	}
+	return nil
+}
+
+// listReader reads the rows of a LIST response. If rowFn is set, each row is
+// passed to it as it is read instead of being stored in rows.
 type listReader struct {
 	baseReader
-	variables map[string]string
+	rows    [][]string
+	maxRows int
+	rowFn   func([]string)
 }
 
 func (l *listReader) parse(r io.Reader) error {
-	l.baseReader.scanner = bufio.NewScanner(r)
-	l.baseReader.scanner.Split(split)
-	l.variables = map[string]string{}
-	if !l.expectKeyword("begin") ||
-		!l.expectKeyword("list") ||
-		!l.expectKeyword("var") ||
-		!l.next() {
+	l.init(r)
+	l.rows = [][]string{}
+	count := 0
+	if err := l.next(); err != nil {
+		return err
+	}
+	prefix, ok := trimPrefix(l.tokens, "begin", "list")
+	if !ok {
 		return errBeginListMissing
 	}
-	for l.next() {
-		if l.isKeyword("end") {
-			if l.expectKeyword("list") &&
-				l.expectKeyword("var") &&
-				l.next() {
-				return nil
-			}
-			return errUnexpectedEof
+	for {
+		if err := l.next(); err != nil {
+			return err
 		}
-		if !l.isKeyword("var") {
-			return errVarExpected
+		if _, ok := trimPrefix(l.tokens, "end", "list"); ok {
+			return nil
 		}
-		if !l.next() {
-			return errUnexpectedEof
+		row, ok := trimPrefix(l.tokens, prefix...)
+		if !ok {
+			return errRowExpected
+		}
+		if l.maxRows > 0 && count == l.maxRows {
+			return errTooManyRows
 		}
-		if !l.next() {
-			return errVarNameMissing
+		count++
+		if l.rowFn != nil {
+			l.rowFn(row)
+			continue
 		}
-		varName := l.scanner.Text()
-		if !l.next() {
-			return errVarValueMissing
+		l.rows = append(l.rows, row)
+	}
+}
+
+// parseVariables converts the rows of a LIST VAR response into a map.
+func parseVariables(rows [][]string) (map[string]string, error) {
+	variables := map[string]string{}
+	for _, row := range rows {
+		switch len(row) {
+		case 0:
+			return nil, errVarNameMissing
+		case 1:
+			return nil, errVarValueMissing
+		}
+		variables[row[0]] = row[1]
+	}
+	return variables, nil
+}
+
+// parseValues returns the first token from each row of a LIST response.
+func parseValues(rows [][]string) ([]string, error) {
+	values := []string{}
+	for _, row := range rows {
+		if len(row) == 0 {
+			return nil, errRowExpected
 		}
-		l.variables[varName] = l.scanner.Text()
+		values = append(values, row[0])
 	}
-	return errUnexpectedEof
+	return values, nil
 }
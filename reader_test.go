@@ -2,6 +2,9 @@ package nutclient
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
+	"io"
 	"reflect"
 	"strings"
 	"testing"
@@ -29,6 +32,16 @@ func TestSplit(t *testing.T) {
 			input:  "a \"b c\" d",
 			output: []string{"a", "b c", "d"},
 		},
+		{
+			name:   "string (escaped quote)",
+			input:  `a "it's a \"test\"" d`,
+			output: []string{"a", `it's a "test"`, "d"},
+		},
+		{
+			name:   "string (escaped backslash)",
+			input:  `a "b\\" d`,
+			output: []string{"a", `b\`, "d"},
+		},
 		{
 			name:   "string (error)",
 			input:  "a \"b",
@@ -54,6 +67,91 @@ func TestSplit(t *testing.T) {
 	}
 }
 
+func TestFormatCommand(t *testing.T) {
+	for _, v := range []string{
+		"ups",
+		"my ups",
+		`it's a "test"`,
+		`C:\\path`,
+		"tab\tseparated",
+	} {
+		args := []string{"GET", "VAR", v, "ups.status"}
+		output, err := tokenize(formatCommand(args))
+		if err != nil {
+			t.Fatalf("%#v: %s", v, err)
+		}
+		if !reflect.DeepEqual(args, output) {
+			t.Fatalf("%#v: %#v != %#v", v, args, output)
+		}
+	}
+}
+
+func TestLineReader(t *testing.T) {
+	for _, v := range []struct {
+		name   string
+		input  string
+		output []string
+		err    bool
+	}{
+		{
+			name:   "LF line ending",
+			input:  "VAR ups ups.status \"OL\"\n",
+			output: []string{"VAR", "ups", "ups.status", "OL"},
+		},
+		{
+			name:   "CRLF line ending",
+			input:  "VAR ups ups.status \"OL\"\r\n",
+			output: []string{"VAR", "ups", "ups.status", "OL"},
+		},
+		{
+			name:  "empty input",
+			input: "",
+			err:   true,
+		},
+	} {
+		var (
+			l   = &lineReader{}
+			err = l.parse(strings.NewReader(v.input))
+		)
+		if err != nil {
+			if !v.err {
+				t.Fatalf("%s: %s", v.name, err)
+			}
+		} else {
+			if !reflect.DeepEqual(v.output, l.tokens) {
+				t.Fatalf("%s: %#v != %#v", v.name, v.output, l.tokens)
+			}
+		}
+	}
+}
+
+func TestRawReader(t *testing.T) {
+	for _, v := range []struct {
+		name   string
+		input  string
+		output string
+	}{
+		{
+			name:   "LF line ending",
+			input:  "Network UPS Tools upsd 2.8.0\n",
+			output: "Network UPS Tools upsd 2.8.0",
+		},
+		{
+			name:   "CRLF line ending",
+			input:  "Network UPS Tools upsd 2.8.0\r\n",
+			output: "Network UPS Tools upsd 2.8.0",
+		},
+	} {
+		r := &rawReader{}
+		if err := r.parse(strings.NewReader(v.input)); err != nil {
+			t.Fatalf("%s: %s", v.name, err)
+		}
+		if r.line != v.output {
+			t.Fatalf("%s: %#v != %#v", v.name, v.output, r.line)
+		}
+	}
+}
+
 func TestListReader(t *testing.T) {
 	for _, v := range []struct {
 		name   string
@@ -83,19 +181,121 @@ END LIST VAR ups`,
 				"k2": "v2",
 			},
 		},
+		{
+			name:  "CRLF line endings",
+			input: "BEGIN LIST VAR ups\r\nVAR ups k1 \"v1\"\r\nEND LIST VAR ups\r\n",
+			output: map[string]string{
+				"k1": "v1",
+			},
+		},
+		{
+			name: "long value",
+			input: fmt.Sprintf(`BEGIN LIST VAR ups
+VAR ups k1 "%s"
+END LIST VAR ups`, strings.Repeat("v", 128*1024)),
+			output: map[string]string{
+				"k1": strings.Repeat("v", 128*1024),
+			},
+		},
+		{
+			name: "mismatched row",
+			input: `BEGIN LIST VAR ups
+VAR ups2 k1 "v1"
+END LIST VAR ups`,
+			err: true,
+		},
+		{
+			name:  "server error",
+			input: "ERR UNKNOWN-UPS",
+			err:   true,
+		},
 	} {
 		var (
 			l   = &listReader{}
 			err = l.parse(strings.NewReader(v.input))
 		)
+		var variables map[string]string
+		if err == nil {
+			variables, err = parseVariables(l.rows)
+		}
 		if err != nil {
 			if !v.err {
 				t.Fatalf("%s: %s", v.name, err)
 			}
 		} else {
-			if !reflect.DeepEqual(v.output, l.variables) {
-				t.Fatalf("%s: %#v != %#v", v.name, v.output, l.variables)
+			if !reflect.DeepEqual(v.output, variables) {
+				t.Fatalf("%s: %#v != %#v", v.name, v.output, variables)
+			}
+		}
+	}
+}
+
+// errorReader returns the data it contains followed by err.
+type errorReader struct {
+	data string
+	err  error
+}
+
+func (e *errorReader) Read(p []byte) (int, error) {
+	if len(e.data) == 0 {
+		return 0, e.err
+	}
+	n := copy(p, e.data)
+	e.data = e.data[n:]
+	return n, nil
+}
+
+func TestListReaderError(t *testing.T) {
+	errReset := errors.New("connection reset")
+	for _, v := range []struct {
+		name string
+		err  error
+		is   error
+	}{
+		{
+			name: "connection reset",
+			err:  errReset,
+			is:   errReset,
+		},
+		{
+			name: "truncated",
+			err:  io.EOF,
+			is:   ErrServerClosed,
+		},
+	} {
+		var (
+			l = &listReader{}
+			r = &errorReader{
+				data: "BEGIN LIST VAR ups\nVAR ups k1 \"v1\"\n",
+				err:  v.err,
 			}
+			err = l.parse(r)
+		)
+		if !errors.Is(err, v.is) {
+			t.Fatalf("%s: %#v != %#v", v.name, v.is, err)
+		}
+	}
+}
+
+func TestListReaderMaxRows(t *testing.T) {
+	for _, v := range []struct {
+		name    string
+		maxRows int
+		err     error
+	}{
+		{name: "at limit", maxRows: 2},
+		{name: "over limit", maxRows: 1, err: errTooManyRows},
+		{name: "unlimited"},
+	} {
+		var (
+			l = &listReader{maxRows: v.maxRows}
+			r = strings.NewReader(`BEGIN LIST VAR ups
+VAR ups k1 "v1"
+VAR ups k2 "v2"
+END LIST VAR ups`)
+		)
+		if err := l.parse(r); !errors.Is(err, v.err) {
+			t.Fatalf("%s: %#v != %#v", v.name, v.err, err)
 		}
 	}
 }
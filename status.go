@@ -0,0 +1,95 @@
+package nutclient
+
+import (
+	"strings"
+)
+
+// StatusFlags provides the meaning of the flags in ups.status.
+type StatusFlags struct {
+	Online         bool
+	OnBattery      bool
+	LowBattery     bool
+	Charging       bool
+	Discharging    bool
+	ReplaceBattery bool
+	Overload       bool
+	Bypass         bool
+	Calibration    bool
+
+	// Unknown contains any flags not listed above, in the order they appear.
+	Unknown []string
+}
+
+// ParseStatus parses the value of ups.status (such as "OB DISCHRG").
+func ParseStatus(s string) StatusFlags {
+	f := StatusFlags{}
+	for _, v := range strings.Fields(s) {
+		switch v {
+		case "OL":
+			f.Online = true
+		case "OB":
+			f.OnBattery = true
+		case "LB":
+			f.LowBattery = true
+		case "CHRG":
+			f.Charging = true
+		case "DISCHRG":
+			f.Discharging = true
+		case "RB":
+			f.ReplaceBattery = true
+		case "OVER":
+			f.Overload = true
+		case "BYPASS":
+			f.Bypass = true
+		case "CAL":
+			f.Calibration = true
+		default:
+			f.Unknown = append(f.Unknown, v)
+		}
+	}
+	return f
+}
+
+// hasAnyFlag determines if any of the flags are present in ups.status.
+func hasAnyFlag(fields, flags []string) bool {
+	for _, f := range fields {
+		for _, flag := range flags {
+			if f == flag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// evaluateStatus determines whether the UPS is running on battery from the
+// flags in ups.status, using the flags in cfg to decide which indicate each
+// state. ok is false if the flags do not indicate either way (such as for
+// "BYPASS" or an empty status).
+func evaluateStatus(status string, cfg *Config) (onBattery, ok bool) {
+	fields := strings.Fields(status)
+	switch {
+	case hasAnyFlag(fields, cfg.getOnBatteryFlags()):
+		return true, true
+	case hasAnyFlag(fields, cfg.getOnLineFlags()):
+		return false, true
+	case hasAnyFlag(fields, []string{"LB"}):
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// evaluateCharge determines whether the UPS is running on battery from the
+// change in battery charge since the previous poll. If the charge did not
+// change, the previous value is retained.
+func evaluateCharge(charge, lastCharge float64, onBattery bool) bool {
+	switch {
+	case charge < lastCharge:
+		return true
+	case charge > lastCharge:
+		return false
+	default:
+		return onBattery
+	}
+}
@@ -0,0 +1,119 @@
+package nutclient
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStatus(t *testing.T) {
+	for _, v := range []struct {
+		input  string
+		output StatusFlags
+	}{
+		{
+			input:  "",
+			output: StatusFlags{},
+		},
+		{
+			input: "OL CHRG",
+			output: StatusFlags{
+				Online:   true,
+				Charging: true,
+			},
+		},
+		{
+			input: "OB DISCHRG LB",
+			output: StatusFlags{
+				OnBattery:   true,
+				Discharging: true,
+				LowBattery:  true,
+			},
+		},
+		{
+			input: "OL RB OVER BYPASS",
+			output: StatusFlags{
+				Online:         true,
+				ReplaceBattery: true,
+				Overload:       true,
+				Bypass:         true,
+			},
+		},
+		{
+			input: "OB CAL",
+			output: StatusFlags{
+				OnBattery:   true,
+				Calibration: true,
+			},
+		},
+		{
+			input: "OL TRIM ECO",
+			output: StatusFlags{
+				Online:  true,
+				Unknown: []string{"TRIM", "ECO"},
+			},
+		},
+	} {
+		if output := ParseStatus(v.input); !reflect.DeepEqual(v.output, output) {
+			t.Fatalf("%#v: %#v != %#v", v.input, v.output, output)
+		}
+	}
+}
+
+func TestEvaluateStatus(t *testing.T) {
+	custom := &Config{
+		OnBatteryFlags: []string{"OB", "DISCHRG"},
+		OnLineFlags:    []string{"OL", "ONLINE"},
+	}
+	for _, v := range []struct {
+		status    string
+		cfg       *Config
+		previous  bool
+		onBattery bool
+	}{
+		{status: "OL", previous: true, onBattery: false},
+		{status: "OL CHRG", previous: true, onBattery: false},
+		{status: "OL BOOST", previous: true, onBattery: false},
+		{status: "OB", previous: false, onBattery: true},
+		{status: "OB DISCHRG LB", previous: false, onBattery: true},
+		{status: "LB", previous: false, onBattery: true},
+		{status: "BYPASS", previous: false, onBattery: false},
+		{status: "BYPASS", previous: true, onBattery: true},
+		{status: "", previous: false, onBattery: false},
+		{status: "", previous: true, onBattery: true},
+		{status: "DISCHRG", previous: false, onBattery: false},
+		{status: "DISCHRG", cfg: custom, previous: false, onBattery: true},
+		{status: "ONLINE", previous: true, onBattery: true},
+		{status: "ONLINE", cfg: custom, previous: true, onBattery: false},
+	} {
+		cfg := v.cfg
+		if cfg == nil {
+			cfg = &Config{}
+		}
+		onBattery, ok := evaluateStatus(v.status, cfg)
+		if !ok {
+			onBattery = v.previous
+		}
+		if onBattery != v.onBattery {
+			t.Fatalf("%#v (%v): %#v != %#v", v.status, v.previous, v.onBattery, onBattery)
+		}
+	}
+}
+
+func TestEvaluateCharge(t *testing.T) {
+	for _, v := range []struct {
+		charge     float64
+		lastCharge float64
+		previous   bool
+		onBattery  bool
+	}{
+		{charge: 90, lastCharge: 95, previous: false, onBattery: true},
+		{charge: 95, lastCharge: 90, previous: true, onBattery: false},
+		{charge: 90, lastCharge: 90, previous: false, onBattery: false},
+		{charge: 90, lastCharge: 90, previous: true, onBattery: true},
+	} {
+		onBattery := evaluateCharge(v.charge, v.lastCharge, v.previous)
+		if onBattery != v.onBattery {
+			t.Fatalf("%v -> %v (%v): %#v != %#v", v.lastCharge, v.charge, v.previous, v.onBattery, onBattery)
+		}
+	}
+}
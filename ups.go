@@ -0,0 +1,47 @@
+package nutclient
+
+// UPSClient runs commands for a single UPS using the connection of the
+// Client that created it. It is closed along with that Client.
+type UPSClient struct {
+	client *Client
+	name   string
+}
+
+// ForUPS returns a UPSClient for the specified UPS, which avoids passing its
+// name to every method.
+func (c *Client) ForUPS(name string) *UPSClient {
+	return &UPSClient{
+		client: c,
+		name:   name,
+	}
+}
+
+// Name returns the name of the UPS.
+func (u *UPSClient) Name() string {
+	return u.name
+}
+
+// Get returns the value of the specified variable.
+func (u *UPSClient) Get(name string) (string, error) {
+	return u.client.GetVar(u.name, name)
+}
+
+// Set changes the value of a writable variable.
+func (u *UPSClient) Set(name, value string) error {
+	return u.client.Set(u.name, name, value)
+}
+
+// InstCmd sends an instant command to the UPS.
+func (u *UPSClient) InstCmd(command string) error {
+	return u.client.InstCmd(u.name, command)
+}
+
+// ListVars returns the name and value of each variable.
+func (u *UPSClient) ListVars() (map[string]string, error) {
+	return u.client.ListVars(u.name)
+}
+
+// Status returns the current value of ups.status.
+func (u *UPSClient) Status() (string, error) {
+	return u.Get("ups.status")
+}
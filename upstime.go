@@ -0,0 +1,53 @@
+package nutclient
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// upsTimeLayouts are the layouts used by ParseUPSTime if none are provided,
+// covering the formats used by common drivers for variables such as ups.date,
+// ups.time, and battery.date.
+var upsTimeLayouts = []string{
+	"2006/01/02 15:04:05",
+	"2006-01-02 15:04:05",
+	"01/02/2006 15:04:05",
+	"2006/01/02",
+	"2006-01-02",
+	"01/02/2006",
+	"01/02/06",
+	"15:04:05",
+	"15:04",
+}
+
+// ParseUPSTime parses a date and/or time reported by a UPS, trying each of
+// the layouts in turn. If no layouts are provided, a set of layouts commonly
+// used by NUT drivers is tried. The time is assumed to be in UTC.
+func ParseUPSTime(value string, layouts ...string) (time.Time, error) {
+	if len(layouts) == 0 {
+		layouts = upsTimeLayouts
+	}
+	value = strings.TrimSpace(value)
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf(
+		"unable to parse %q using layouts %q",
+		value,
+		layouts,
+	)
+}
+
+// GetTime returns the value of the specified variable parsed with
+// ParseUPSTime using the provided layouts (or the default ones if none are
+// provided).
+func (c *Client) GetTime(ups, name string, layouts ...string) (time.Time, error) {
+	v, err := c.GetVar(ups, name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ParseUPSTime(v, layouts...)
+}
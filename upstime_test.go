@@ -0,0 +1,55 @@
+package nutclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseUPSTime(t *testing.T) {
+	for _, v := range []struct {
+		input   string
+		layouts []string
+		output  time.Time
+		err     bool
+	}{
+		{
+			input:  "2024/03/15",
+			output: time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			input:  "03/15/24",
+			output: time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			input:  "2024-03-15 12:30:45",
+			output: time.Date(2024, 3, 15, 12, 30, 45, 0, time.UTC),
+		},
+		{
+			input:  "12:30:45",
+			output: time.Date(0, 1, 1, 12, 30, 45, 0, time.UTC),
+		},
+		{
+			input:   "15.03.2024",
+			layouts: []string{"02.01.2006"},
+			output:  time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			input: "not a date",
+			err:   true,
+		},
+	} {
+		output, err := ParseUPSTime(v.input, v.layouts...)
+		if err != nil {
+			if !v.err {
+				t.Fatalf("%#v: %s", v.input, err)
+			}
+			continue
+		}
+		if v.err {
+			t.Fatalf("%#v: error expected", v.input)
+		}
+		if !output.Equal(v.output) {
+			t.Fatalf("%#v: %s != %s", v.input, v.output, output)
+		}
+	}
+}
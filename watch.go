@@ -0,0 +1,82 @@
+package nutclient
+
+import (
+	"context"
+	"time"
+)
+
+// VarChange describes a change to the value of a variable. Old is empty for
+// a variable that was not previously reported and New is empty for one that
+// is no longer reported.
+type VarChange struct {
+	Name string
+	Old  string
+	New  string
+}
+
+// watch lists the variables of the UPS every interval, sending any changes to
+// ch until ctx is done or the client is closed.
+func (c *Client) watch(ctx context.Context, ups string, interval time.Duration, ch chan<- VarChange) {
+	defer close(ch)
+	ticker := c.clock.NewTicker(interval)
+	defer ticker.Stop()
+	var variables map[string]string
+	for {
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			return
+		case <-c.closedChan:
+			return
+		}
+		v, err := c.sendContext(ctx, typeList, "VAR", ups)
+		if err != nil {
+			continue
+		}
+		newVariables, err := parseVariables(v.([][]string))
+		if err != nil {
+			continue
+		}
+
+		// The first listing provides the values to compare against
+		if variables == nil {
+			variables = newVariables
+			continue
+		}
+		changes := []VarChange{}
+		for name, value := range newVariables {
+			if old, ok := variables[name]; !ok || old != value {
+				changes = append(changes, VarChange{Name: name, Old: old, New: value})
+			}
+		}
+		for name, old := range variables {
+			if _, ok := newVariables[name]; !ok {
+				changes = append(changes, VarChange{Name: name, Old: old})
+			}
+		}
+		variables = newVariables
+		for _, change := range changes {
+			select {
+			case ch <- change:
+			case <-ctx.Done():
+				return
+			case <-c.closedChan:
+				return
+			}
+		}
+	}
+}
+
+// Watch lists the variables of the specified UPS every interval and sends a
+// VarChange to the returned channel for each variable that changed since the
+// previous listing. Listings that fail are skipped. The returned function
+// stops watching; the channel is closed once it is called or the client is
+// closed.
+func (c *Client) Watch(ups string, interval time.Duration) (<-chan VarChange, func()) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		ch          = make(chan VarChange)
+	)
+	go c.watch(ctx, ups, interval, ch)
+	return ch, cancel
+}